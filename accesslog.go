@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// accessEntry is one line of the access log.
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	DurationMS float64   `json:"duration_ms"`
+	Bytes      int64     `json:"bytes"`
+	RequestID  string    `json:"request_id"`
+	ClientIP   string    `json:"client_ip"`
+}
+
+// openAccessLog returns the writer of the access log: nil when path is
+// empty, stdout for "-" or "stdout", and otherwise the file at path opened
+// for appending.
+func openAccessLog(path string) (io.Writer, error) {
+	switch path {
+	case "":
+		return nil, nil
+	case "-", "stdout":
+		return os.Stdout, nil
+	}
+
+	return os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+}
+
+// withAccessLog writes one JSON object per request to out, apart from the
+// application log. It is a no-op when out is nil.
+func withAccessLog(out io.Writer, next http.Handler) http.Handler {
+	if out == nil {
+		return next
+	}
+
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &accessRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		entry := accessEntry{
+			Time:       start.UTC(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rec.status,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Bytes:      rec.bytes,
+			RequestID:  requestID(r.Context()),
+			ClientIP:   ip,
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(entry)
+	})
+}
+
+// accessRecorder captures the status and size of a response.
+type accessRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+
+	return n, err
+}
+
+func (w *accessRecorder) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		fl.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// lift the write deadline of streamed responses.
+func (w *accessRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
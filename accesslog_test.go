@@ -0,0 +1,11 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestAccessLogWriteDeadline(t *testing.T) {
+	assertDeadline(t, withAccessLog(io.Discard, http.HandlerFunc(deadlineHandler)))
+}
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+type shardsSummary struct {
+	Total      int `json:"total"`
+	Successful int `json:"successful"`
+	Failed     int `json:"failed"`
+}
+
+type shardsResponse struct {
+	Index  string        `json:"index"`
+	Shards shardsSummary `json:"shards"`
+}
+
+func refreshHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return shardsHandler(logger, func(r *http.Request) (*esapi.Response, error) {
+		return esapi.IndicesRefreshRequest{Index: []string{peopleIndex}}.Do(r.Context(), es)
+	})
+}
+
+func flushHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return shardsHandler(logger, func(r *http.Request) (*esapi.Response, error) {
+		return esapi.IndicesFlushRequest{Index: []string{peopleIndex}}.Do(r.Context(), es)
+	})
+}
+
+// clearCacheHandler clears the caches of the people index, e.g. between cold
+// and warm benchmark runs. The query, request and fielddata parameters pick
+// the caches to clear, all of them when none is set.
+func clearCacheHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		req := esapi.IndicesClearCacheRequest{Index: []string{peopleIndex}}
+		var err error
+		if req.Query, err = optionalBool(r, "query"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Request, err = optionalBool(r, "request"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if req.Fielddata, err = optionalBool(r, "fielddata"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		res, err := req.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		writeShards(w, res)
+	}
+}
+
+// optionalBool parses a boolean query parameter, returning nil when it is
+// absent.
+func optionalBool(r *http.Request, name string) (*bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return nil, nil
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be true or false", name)
+	}
+
+	return &b, nil
+}
+
+// shardsHandler serves a POST-only admin operation on the people index that
+// responds with a shard summary.
+func shardsHandler(logger *log.Logger,
+	do func(r *http.Request) (*esapi.Response, error)) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		res, err := do(r)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		writeShards(w, res)
+	}
+}
+
+// writeShards reports the shard summary of an index-level operation such as
+// refresh or flush.
+func writeShards(w http.ResponseWriter, res *esapi.Response) {
+	if err := eserr.FromResponse(res); err != nil {
+		if eserr.IsIndexNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("index %q not found", peopleIndex))
+			return
+		}
+		writeESError(w, err)
+		return
+	}
+
+	var body struct {
+		Shards shardsSummary `json:"_shards"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, shardsResponse{Index: peopleIndex, Shards: body.Shards})
+}
+
+type analyzeToken struct {
+	Token       string `json:"token"`
+	StartOffset int    `json:"start_offset"`
+	EndOffset   int    `json:"end_offset"`
+	Type        string `json:"type"`
+	Position    int    `json:"position"`
+}
+
+// analyzeHandler shows how Elasticsearch tokenizes text, either with the
+// analyzer of a mapped field or with a named analyzer.
+func analyzeHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		q := r.URL.Query()
+		text, field, analyzer := q.Get("text"), q.Get("field"), q.Get("analyzer")
+		if text == "" {
+			writeError(w, http.StatusBadRequest, "text is required")
+			return
+		}
+		if field != "" && analyzer != "" {
+			writeError(w, http.StatusBadRequest, "field and analyzer are mutually exclusive")
+			return
+		}
+
+		body := map[string]string{"text": text}
+		if field != "" {
+			body["field"] = field
+		}
+		if analyzer != "" {
+			body["analyzer"] = analyzer
+		}
+		payload, _ := json.Marshal(body)
+
+		res, err := esapi.IndicesAnalyzeRequest{
+			Index: peopleIndex,
+			Body:  bytes.NewReader(payload),
+		}.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		var tokens struct {
+			Tokens []analyzeToken `json:"tokens"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&tokens); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// updateByQueryTimeout caps an update by query below the server write
+// timeout, so the client gets an answer either way.
+const updateByQueryTimeout = 8 * time.Second
+
+// updateByQueryBody is the body of POST /people:update-by-query. Confirm
+// must be true for the update to run.
+type updateByQueryBody struct {
+	Script  string                 `json:"script"`
+	Params  map[string]interface{} `json:"params"`
+	Filters map[string]string      `json:"filters"`
+	Confirm bool                   `json:"confirm"`
+}
+
+type updateByQueryResponse struct {
+	Took             int      `json:"took"`
+	Total            int      `json:"total"`
+	Updated          int      `json:"updated"`
+	VersionConflicts int      `json:"version_conflicts"`
+	Failures         []string `json:"failures,omitempty"`
+}
+
+// updateByQueryHandler runs a painless script over every person matching
+// the filters, e.g. to normalize a country name.
+func updateByQueryHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body updateByQueryBody
+		if err := decodeJSONBody(w, r, &body, true); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if body.Script == "" {
+			writeError(w, http.StatusBadRequest, "script is required")
+			return
+		}
+		if !body.Confirm {
+			writeError(w, http.StatusBadRequest, `updating by query requires "confirm": true`)
+			return
+		}
+
+		filters := make([]interface{}, 0, len(body.Filters))
+		for name, value := range body.Filters {
+			if _, ok := filterFields[name]; !ok {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("cannot filter on %q", name))
+				return
+			}
+			filters = append(filters, termFilter(name, value))
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"query": map[string]interface{}{"bool": map[string]interface{}{"filter": filters}},
+			"script": map[string]interface{}{
+				"lang":   "painless",
+				"source": body.Script,
+				"params": body.Params,
+			},
+		})
+
+		ctx, cancel := context.WithTimeout(r.Context(), updateByQueryTimeout)
+		defer cancel()
+
+		refresh := true
+		res, err := esapi.UpdateByQueryRequest{
+			Index:     []string{peopleIndex},
+			Body:      bytes.NewReader(payload),
+			Conflicts: "proceed",
+			Refresh:   &refresh,
+		}.Do(ctx, es)
+		if errors.Is(err, context.DeadlineExceeded) {
+			writeError(w, http.StatusGatewayTimeout, fmt.Sprintf(
+				"update by query did not finish within %s, it may still be running on the cluster",
+				updateByQueryTimeout))
+			return
+		}
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		var result struct {
+			Took             int `json:"took"`
+			Total            int `json:"total"`
+			Updated          int `json:"updated"`
+			VersionConflicts int `json:"version_conflicts"`
+			Failures         []struct {
+				ID    string `json:"id"`
+				Cause struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"cause"`
+			} `json:"failures"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		out := updateByQueryResponse{
+			Took:             result.Took,
+			Total:            result.Total,
+			Updated:          result.Updated,
+			VersionConflicts: result.VersionConflicts,
+		}
+		for _, f := range result.Failures {
+			out.Failures = append(out.Failures, fmt.Sprintf("%s: %s: %s", f.ID, f.Cause.Type, f.Cause.Reason))
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+// rawSearchHandler passes a complete query DSL body through to Elasticsearch
+// and responds like /search. It bypasses the query builder, so only the size
+// of the body and of the result page are checked, and -default-filter still
+// applies unless include_all=true is passed.
+func rawSearchHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body map[string]interface{}
+		if err := decodeJSONBody(w, r, &body, false); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid search body: %v", err))
+			return
+		}
+
+		from, err := rawIntField(body, "from", 0)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		size, err := rawIntField(body, "size", 10)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		switch {
+		case size < 0 || size > maxSize:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("size must be between 0 and %d", maxSize))
+			return
+		case from < 0 || from+size > maxResultWindow:
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("from + size must not exceed %d", maxResultWindow))
+			return
+		}
+
+		if defaultFilter != nil && !includeAll(r) {
+			query, _ := body["query"].(map[string]interface{})
+			if query == nil {
+				query = map[string]interface{}{"match_all": map[string]interface{}{}}
+			}
+			body["query"] = scoped(query, false)
+		}
+		payload, _ := json.Marshal(body)
+
+		res, err := es.Search(
+			es.Search.WithContext(r.Context()),
+			es.Search.WithIndex(peopleIndex),
+			es.Search.WithBody(bytes.NewReader(payload)),
+		)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		out, err := transformSearch(res.Body)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		if out.Total != nil {
+			setTotalHits(w, *out.Total, out.TotalRelation)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := encodeSearchJSON(jsonOutput(w, r), out); err != nil {
+			logger.Println("search aborted:", err)
+		}
+	}
+}
+
+// rawIntField returns the integer at key of a raw search body, or def when
+// it is absent.
+func rawIntField(body map[string]interface{}, key string, def int) (int, error) {
+	v, ok := body[key]
+	if !ok {
+		return def, nil
+	}
+
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+
+	return int(i), nil
+}
+
+type rolloverBody struct {
+	MaxDocs int    `json:"max_docs"`
+	MaxAge  string `json:"max_age"`
+	MaxSize string `json:"max_size"`
+	DryRun  bool   `json:"dry_run"`
+}
+
+type rolloverResponse struct {
+	Alias      string          `json:"alias"`
+	OldIndex   string          `json:"old_index"`
+	NewIndex   string          `json:"new_index"`
+	RolledOver bool            `json:"rolled_over"`
+	DryRun     bool            `json:"dry_run"`
+	Conditions map[string]bool `json:"conditions"`
+}
+
+// rolloverHandler rolls the people alias over to a new index when one of the
+// max_docs, max_age or max_size conditions is met. It only works when people
+// is an alias with a write index, e.g. people-000001 created from the
+// -index-template-pattern template.
+func rolloverHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body rolloverBody
+		if err := decodeJSONBody(w, r, &body, true); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if body.MaxDocs < 0 {
+			writeError(w, http.StatusBadRequest, "max_docs must be a positive integer")
+			return
+		}
+
+		conditions := make(map[string]interface{})
+		if body.MaxDocs > 0 {
+			conditions["max_docs"] = body.MaxDocs
+		}
+		if body.MaxAge != "" {
+			conditions["max_age"] = body.MaxAge
+		}
+		if body.MaxSize != "" {
+			conditions["max_size"] = body.MaxSize
+		}
+		if len(conditions) == 0 {
+			writeError(w, http.StatusBadRequest, "at least one of max_docs, max_age or max_size is required")
+			return
+		}
+
+		// Elasticsearch rejects rolling over a concrete index with a 400
+		// that reads as a bad body, so say what is wrong instead.
+		alias, err := esapi.IndicesExistsAliasRequest{Name: []string{peopleIndex}}.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		err = eserr.FromResponse(alias)
+		alias.Body.Close()
+		if eserr.IsNotFound(err) {
+			writeError(w, http.StatusConflict,
+				fmt.Sprintf("%q is a concrete index, rollover needs it to be an alias with a write index", peopleIndex))
+			return
+		}
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{"conditions": conditions})
+		res, err := esapi.IndicesRolloverRequest{
+			Alias:  peopleIndex,
+			Body:   bytes.NewReader(payload),
+			DryRun: &body.DryRun,
+		}.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		out := rolloverResponse{Alias: peopleIndex}
+		if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
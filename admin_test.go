@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRolloverAlias(t *testing.T) {
+	tests := []struct {
+		name        string
+		aliasStatus int
+		want        int
+	}{
+		{"concrete index", http.StatusNotFound, http.StatusConflict},
+		{"alias", http.StatusOK, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rolledOver bool
+			es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodHead && r.URL.Path == "/_alias/people":
+					w.WriteHeader(tt.aliasStatus)
+				case r.Method == http.MethodPost && r.URL.Path == "/people/_rollover":
+					rolledOver = true
+					w.Header().Set("Content-Type", "application/json")
+					w.Write([]byte(`{"old_index":"people-000001","new_index":"people-000002","rolled_over":true}`))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+			})
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/rollover", strings.NewReader(`{"max_docs":10}`))
+			rolloverHandler(discardLogger, es)(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if rolledOver != (tt.want == http.StatusOK) {
+				t.Errorf("rolled over = %v", rolledOver)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// decodeJSONBody reads a request body of at most maxSearchBody bytes and
+// decodes it into v, rejecting anything after the value. Numbers decoded
+// into interface values are kept as json.Number. Malformed or truncated
+// bodies are reported with the line and column where parsing failed, so
+// clients can fix their payloads.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}, disallowUnknown bool) error {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSearchBody))
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if disallowUnknown {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return jsonBodyError(data, err)
+	}
+	if rest := bytes.TrimLeft(data[dec.InputOffset():], " \t\r\n"); len(rest) > 0 {
+		offset := int64(len(data) - len(rest))
+		return fmt.Errorf("unexpected data after the JSON value at %s", jsonPosition(data, offset))
+	}
+	return nil
+}
+
+// bodyErrorStatus returns the status reporting an error of decodeJSONBody:
+// 413 for a body over maxSearchBody bytes, 400 otherwise.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// jsonBodyError describes a decode error of data with its position.
+func jsonBodyError(data []byte, err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("empty body")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return fmt.Errorf("unexpected end of JSON at %s", jsonPosition(data, int64(len(data))))
+	case errors.As(err, &syntax):
+		return fmt.Errorf("malformed JSON at %s: %v", jsonPosition(data, syntax.Offset), syntax)
+	case errors.As(err, &typ):
+		return fmt.Errorf("field %q must be %s, not %s, at %s", typ.Field, typ.Type, typ.Value, jsonPosition(data, typ.Offset))
+	}
+	return err
+}
+
+// jsonPosition converts a byte offset in data to a line and column, both
+// counted from 1.
+func jsonPosition(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("line %d, column %d (offset %d)", line, column, offset)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONBody(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "truncated",
+			body: "{\n \"ids\": [\"a\",",
+			want: "unexpected end of JSON at line 2, column 14 (offset 15)",
+		},
+		{
+			name: "bad token",
+			body: "{\n \"ids\": [\"a\"}",
+			want: "malformed JSON at line 2, column 14 (offset 15): invalid character '}' after array element",
+		},
+		{
+			name: "wrong type",
+			body: `{"ids": 3}`,
+			want: `field "ids" must be []string, not number, at line 1, column 10 (offset 9)`,
+		},
+		{
+			name: "empty",
+			body: "",
+			want: "empty body",
+		},
+		{
+			name: "unknown field",
+			body: `{"id": "a"}`,
+			want: `json: unknown field "id"`,
+		},
+		{
+			name: "second value",
+			body: `{"ids": ["a"]} {"ids": ["b"]}`,
+			want: "unexpected data after the JSON value at line 1, column 16 (offset 15)",
+		},
+		{
+			name: "unbalanced brace",
+			body: `{"ids": ["a"]}}`,
+			want: "unexpected data after the JSON value at line 1, column 15 (offset 14)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/people:mget", strings.NewReader(tt.body))
+			var v struct {
+				IDs []string `json:"ids"`
+			}
+			err := decodeJSONBody(httptest.NewRecorder(), r, &v, true)
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("decodeJSONBody() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestCreatePersonTruncatedBody checks a write endpoint answers a truncated
+// body with a 400 naming where parsing failed.
+func TestCreatePersonTruncatedBody(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request to elastic: %s %s", r.Method, r.URL)
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/people", strings.NewReader(`{"first_name": "Rob", "last_na`))
+	rec := httptest.NewRecorder()
+	createPersonHandler(discardLogger, es)(rec, r)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+	want := `{"error":"invalid body: unexpected end of JSON at line 1, column 31 (offset 30)"}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestDecodeJSONBodyTrailingWhitespace(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/people:mget", strings.NewReader("{\"ids\": [\"a\"]}\n\n "))
+	var v struct {
+		IDs []string `json:"ids"`
+	}
+	if err := decodeJSONBody(httptest.NewRecorder(), r, &v, true); err != nil {
+		t.Errorf("decodeJSONBody() error = %v", err)
+	}
+}
+
+// TestSearchBodyErrors checks POST /search bodies are read like those of the
+// other endpoints.
+func TestSearchBodyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"too large", `{"q": "` + strings.Repeat("a", maxSearchBody) + `"}`, http.StatusRequestEntityTooLarge},
+		{"trailing data", `{"q": "rob"} {"q": "pike"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/search", strings.NewReader(tt.body))
+			_, err := parseSearchQuery(httptest.NewRecorder(), r)
+			if err == nil {
+				t.Fatal("parseSearchQuery() accepted the body")
+			}
+			if got := bodyErrorStatus(err); got != tt.code {
+				t.Errorf("status of %v = %d, want %d", err, got, tt.code)
+			}
+		})
+	}
+}
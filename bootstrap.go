@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
+)
+
+// loadSynonyms reads synonym rules in the Solr format, one rule per line.
+// Blank lines and lines starting with # are ignored.
+func loadSynonyms(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rules = append(rules, line)
+	}
+
+	return rules, nil
+}
+
+func indexSettings(synonyms []string) []byte {
+	filters := []string{"lowercase"}
+	filter := map[string]interface{}{}
+	if len(synonyms) > 0 {
+		filters = append(filters, "country_synonyms")
+		filter["country_synonyms"] = map[string]interface{}{
+			"type":     "synonym",
+			"synonyms": synonyms,
+		}
+	}
+
+	analyzers := map[string]interface{}{
+		"country_analyzer": map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    filters,
+		},
+	}
+	if phoneticNames {
+		filter["name_phonetic"] = map[string]interface{}{
+			"type":    "phonetic",
+			"encoder": phoneticEncoder,
+			"replace": true,
+		}
+		analyzers["phonetic_analyzer"] = map[string]interface{}{
+			"type":      "custom",
+			"tokenizer": "standard",
+			"filter":    []string{"lowercase", "name_phonetic"},
+		}
+	}
+
+	body := map[string]interface{}{
+		"settings": map[string]interface{}{
+			"analysis": map[string]interface{}{
+				"filter":   filter,
+				"analyzer": analyzers,
+				"normalizer": map[string]interface{}{
+					"lowercase_normalizer": map[string]interface{}{
+						"type":   "custom",
+						"filter": []string{"lowercase"},
+					},
+				},
+			},
+		},
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id":         map[string]interface{}{"type": "keyword"},
+				"first_name": nameMapping(),
+				"last_name":  nameMapping(),
+				"full_name":  map[string]interface{}{"type": "text"},
+				"title": map[string]interface{}{
+					"type":          "text",
+					"index_options": "offsets",
+					"fields": languageSubFields(map[string]interface{}{
+						"keyword":   map[string]interface{}{"type": "keyword", "ignore_above": 256},
+						"lowercase": lowercaseMapping(),
+					}),
+				},
+				"country": map[string]interface{}{
+					"type":        "text",
+					"analyzer":    "country_analyzer",
+					"term_vector": "with_positions_offsets",
+					"fields": map[string]interface{}{
+						"keyword":   map[string]interface{}{"type": "keyword"},
+						"lowercase": lowercaseMapping(),
+						"plain":     plainMapping(),
+					},
+				},
+				"email": map[string]interface{}{
+					"type": "text",
+					"fields": map[string]interface{}{
+						"keyword":   map[string]interface{}{"type": "keyword", "ignore_above": 256},
+						"lowercase": lowercaseMapping(),
+					},
+				},
+				"address": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"street": map[string]interface{}{"type": "text"},
+						"city": map[string]interface{}{
+							"type":        "text",
+							"term_vector": "with_positions_offsets",
+							"fields": map[string]interface{}{
+								"keyword":   map[string]interface{}{"type": "keyword"},
+								"lowercase": lowercaseMapping(),
+								"plain":     plainMapping(),
+							},
+						},
+						"postcode": map[string]interface{}{"type": "keyword"},
+					},
+				},
+			},
+		},
+	}
+
+	payload, _ := json.Marshal(body)
+	return payload
+}
+
+// nameMapping maps a name field with a keyword sub-field for sorting and
+// copies it into full_name so the complete name is searchable.
+func nameMapping() map[string]interface{} {
+	fields := languageSubFields(map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword"}})
+	if phoneticNames {
+		fields["phonetic"] = map[string]interface{}{"type": "text", "analyzer": "phonetic_analyzer"}
+	}
+
+	return map[string]interface{}{
+		"type":          "text",
+		"index_options": "offsets",
+		"copy_to":       "full_name",
+		"fields":        fields,
+	}
+}
+
+// lowercaseMapping maps the keyword sub-field matched by exact-value
+// filters, normalized to lowercase so they ignore case.
+func lowercaseMapping() map[string]interface{} {
+	return map[string]interface{}{"type": "keyword", "normalizer": "lowercase_normalizer"}
+}
+
+// plainMapping maps a text sub-field analyzed with the standard analyzer,
+// whose matches matched_fields can merge into the highlights of its parent.
+// Both carry term vectors for the fast vector highlighter.
+func plainMapping() map[string]interface{} {
+	return map[string]interface{}{"type": "text", "term_vector": "with_positions_offsets"}
+}
+
+// languageSubFields adds a sub-field analyzed by the analyzer of each of the
+// -languages to fields, e.g. title.de with the german analyzer.
+func languageSubFields(fields map[string]interface{}) map[string]interface{} {
+	for code, analyzer := range languages {
+		fields[code] = map[string]interface{}{"type": "text", "analyzer": analyzer}
+	}
+
+	return fields
+}
+
+const (
+	// bootstrapRecreate deletes the index and recreates and seeds it.
+	bootstrapRecreate = "recreate"
+	// bootstrapEnsure creates and seeds the index only when it is missing and
+	// otherwise applies the dynamic settings of an existing index.
+	bootstrapEnsure = "ensure"
+)
+
+type bootstrapOptions struct {
+	Mode     string
+	Synonyms []string
+	// Settings are index settings applied to an existing index in ensure
+	// mode, keyed by setting name.
+	Settings map[string]interface{}
+	// TemplatePattern, when set, installs an index template for indices
+	// matching it. TemplateBody holds the template settings and mappings,
+	// defaulting to those of the people index.
+	TemplatePattern string
+	TemplateBody    []byte
+	// LifecyclePolicy, when set, is installed as the ILM policy named after
+	// the index and attached to the index template.
+	LifecyclePolicy []byte
+	// DryRun only logs the operations bootstrap would perform.
+	DryRun bool
+	// BatchSize is the number of documents per bulk request and Workers the
+	// number of bulk requests sent concurrently while seeding.
+	BatchSize int
+	Workers   int
+}
+
+// bootstrap prepares the people index according to opts.Mode.
+func bootstrap(es *elasticsearch.Client, logger *log.Logger, opts bootstrapOptions) error {
+	idx := peopleIndex
+	ctx := context.Background()
+
+	if opts.TemplatePattern != "" {
+		if opts.LifecyclePolicy != nil {
+			err := putLifecyclePolicy(ctx, es, logger, opts)
+			if eserr.IsUnsupported(err) {
+				logger.Printf("Skipping ILM policy %q: %v", peopleIndex, err)
+				opts.LifecyclePolicy = nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if err := putTemplate(ctx, es, logger, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.Mode == bootstrapEnsure {
+		res, err := esapi.IndicesExistsRequest{Index: []string{idx}}.Do(ctx, es)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode == http.StatusOK {
+			return applySettings(ctx, es, logger, opts)
+		}
+	} else if opts.DryRun {
+		logger.Printf("dry-run: would delete index %q", idx)
+	} else {
+		_, err := esapi.IndicesDeleteRequest{Index: []string{idx}}.Do(ctx, es)
+		if err != nil {
+			return err
+		}
+	}
+
+	return createIndex(ctx, es, logger, opts)
+}
+
+// onDemandBootstrap returns a function creating and seeding the people index
+// if it is missing. Concurrent calls are serialized, so only the first one
+// creates the index.
+func onDemandBootstrap(es *elasticsearch.Client, logger *log.Logger,
+	opts bootstrapOptions) func() error {
+
+	opts.Mode = bootstrapEnsure
+	opts.DryRun = false
+
+	var mu sync.Mutex
+	return func() error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return bootstrap(es, logger, opts)
+	}
+}
+
+func createIndex(ctx context.Context, es *elasticsearch.Client, logger *log.Logger,
+	opts bootstrapOptions) error {
+
+	idx := peopleIndex
+	settings := indexSettings(opts.Synonyms)
+	people, err := seedPeople()
+	if err != nil {
+		return err
+	}
+
+	if phoneticNames && !opts.DryRun {
+		if err := checkPhoneticPlugin(ctx, es); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		logger.Printf("dry-run: would create index %q with %s", idx, settings)
+		logger.Printf("dry-run: would index %d documents into %q", len(people), idx)
+		return nil
+	}
+
+	// An invalid analyzer or synonym fails here; seeding regardless would
+	// create a dynamically mapped index.
+	res, err := esapi.IndicesCreateRequest{
+		Index: idx,
+		Body:  bytes.NewReader(settings),
+	}.Do(ctx, es)
+	if err := checkResponse(res, err); err != nil {
+		return err
+	}
+
+	return bulkCreate(ctx, es, idx, people, opts.BatchSize, opts.Workers)
+}
+
+// checkPhoneticPlugin verifies every node has the analysis-phonetic plugin
+// the phonetic sub-fields of -phonetic are analyzed with, which creating the
+// index would otherwise fail on with an obscure analysis error.
+func checkPhoneticPlugin(ctx context.Context, es *elasticsearch.Client) error {
+	res, err := esapi.NodesInfoRequest{Metric: []string{"plugins"}}.Do(ctx, es)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return err
+	}
+
+	var info struct {
+		Nodes map[string]struct {
+			Name    string `json:"name"`
+			Plugins []struct {
+				Name string `json:"name"`
+			} `json:"plugins"`
+		} `json:"nodes"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, node := range info.Nodes {
+		found := false
+		for _, p := range node.Plugins {
+			found = found || p.Name == "analysis-phonetic"
+		}
+		if !found {
+			missing = append(missing, node.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("-phonetic requires the analysis-phonetic plugin, missing on nodes %s; "+
+			"install it with bin/elasticsearch-plugin install analysis-phonetic", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// putTemplate installs the index template so indices auto-created with a
+// name matching the pattern get the same settings and mappings.
+func putTemplate(ctx context.Context, es *elasticsearch.Client, logger *log.Logger,
+	opts bootstrapOptions) error {
+
+	base := opts.TemplateBody
+	if base == nil {
+		base = indexSettings(opts.Synonyms)
+	}
+
+	var template map[string]interface{}
+	if err := json.Unmarshal(base, &template); err != nil {
+		return fmt.Errorf("invalid index template: %v", err)
+	}
+	template["index_patterns"] = []string{opts.TemplatePattern}
+	if opts.LifecyclePolicy != nil {
+		settings, _ := template["settings"].(map[string]interface{})
+		if settings == nil {
+			settings = map[string]interface{}{}
+			template["settings"] = settings
+		}
+		settings["index.lifecycle.name"] = peopleIndex
+	}
+
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		logger.Printf("dry-run: would put index template %q with %s", peopleIndex, payload)
+		return nil
+	}
+
+	// The pinned client predates composable templates, so the legacy
+	// template API is used.
+	res, err := esapi.IndicesPutTemplateRequest{
+		Name: peopleIndex,
+		Body: bytes.NewReader(payload),
+	}.Do(ctx, es)
+	if err := checkResponse(res, err); err != nil {
+		return err
+	}
+
+	logger.Printf("Installed index template %q for %q", peopleIndex, opts.TemplatePattern)
+	return nil
+}
+
+// putLifecyclePolicy installs the ILM policy given as
+// {"policy": {"phases": {...}}}, named after the index.
+func putLifecyclePolicy(ctx context.Context, es *elasticsearch.Client, logger *log.Logger,
+	opts bootstrapOptions) error {
+
+	if !json.Valid(opts.LifecyclePolicy) {
+		return fmt.Errorf("invalid ILM policy: not JSON")
+	}
+
+	if opts.DryRun {
+		logger.Printf("dry-run: would put ILM policy %q with %s", peopleIndex, opts.LifecyclePolicy)
+		return nil
+	}
+
+	res, err := esapi.ILMPutLifecycleRequest{
+		Policy: peopleIndex,
+		Body:   bytes.NewReader(opts.LifecyclePolicy),
+	}.Do(ctx, es)
+	if err := checkResponse(res, err); err != nil {
+		return err
+	}
+
+	logger.Printf("Installed ILM policy %q", peopleIndex)
+	return nil
+}
+
+// applySettings updates the dynamic settings of the existing index. Static
+// settings can only be changed by recreating the index and are skipped.
+func applySettings(ctx context.Context, es *elasticsearch.Client, logger *log.Logger,
+	opts bootstrapOptions) error {
+
+	dynamic := map[string]interface{}{}
+	for name, value := range opts.Settings {
+		if !isDynamicSetting(name) {
+			logger.Printf("Skipping static index setting %q, recreate the index to change it", name)
+			continue
+		}
+		dynamic[name] = value
+	}
+
+	if len(dynamic) == 0 {
+		return nil
+	}
+
+	payload, err := json.Marshal(map[string]interface{}{"index": dynamic})
+	if err != nil {
+		return err
+	}
+
+	if opts.DryRun {
+		logger.Printf("dry-run: would update settings of index %q with %s", peopleIndex, payload)
+		return nil
+	}
+
+	res, err := esapi.IndicesPutSettingsRequest{
+		Index: []string{peopleIndex},
+		Body:  bytes.NewReader(payload),
+	}.Do(ctx, es)
+	if err := checkResponse(res, err); err != nil {
+		return err
+	}
+
+	logger.Printf("Updated settings of index %q: %s", peopleIndex, payload)
+	return nil
+}
+
+// dynamicSettings are the index settings that can be changed on a live
+// index. Entries ending in a dot match every setting with that prefix.
+var dynamicSettings = []string{
+	"number_of_replicas",
+	"auto_expand_replicas",
+	"refresh_interval",
+	"max_result_window",
+	"max_inner_result_window",
+	"max_rescore_window",
+	"max_docvalue_fields_search",
+	"max_script_fields",
+	"max_ngram_diff",
+	"max_shingle_diff",
+	"max_refresh_listeners",
+	"max_terms_count",
+	"max_regex_length",
+	"gc_deletes",
+	"default_pipeline",
+	"final_pipeline",
+	"analyze.max_token_count",
+	"highlight.max_analyzed_offset",
+	"search.idle.after",
+	"unassigned.node_left.delayed_timeout",
+	"blocks.",
+	"routing.",
+	"translog.durability",
+	"translog.sync_interval",
+	"translog.flush_threshold_size",
+	"search.slowlog.",
+	"indexing.slowlog.",
+}
+
+func isDynamicSetting(name string) bool {
+	for _, s := range dynamicSettings {
+		if name == s || (strings.HasSuffix(s, ".") && strings.HasPrefix(name, s)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadIndexSettings reads index settings from a JSON file, flattening nested
+// objects into dotted names and dropping the "index." prefix.
+func loadIndexSettings(path string) (map[string]interface{}, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	settings := map[string]interface{}{}
+	flattenSettings("", raw, settings)
+
+	return settings, nil
+}
+
+func flattenSettings(prefix string, raw, out map[string]interface{}) {
+	for k, v := range raw {
+		name := strings.TrimPrefix(prefix+k, "index.")
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenSettings(name+".", nested, out)
+			continue
+		}
+		out[name] = v
+	}
+}
+
+// seedData holds the people indexed by bootstrap, one JSON document per line.
+//
+//go:embed people.ndjson
+var seedData []byte
+
+func seedPeople() ([]*Person, error) {
+	var people []*Person
+	r := ndjson.NewReader(bytes.NewReader(seedData))
+	for {
+		var p Person
+		err := r.Decode(&p)
+		if err == io.EOF {
+			return people, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("seed data: %w", err)
+		}
+		people = append(people, &p)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// TestCreateIndexFailure checks a rejected index creation is reported
+// instead of seeding a dynamically mapped index.
+func TestCreateIndexFailure(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.URL.Path != "/people" {
+			t.Errorf("unexpected request after the failed creation: %s %s", r.Method, r.URL.Path)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":{"type":"illegal_argument_exception","reason":"failed to build synonyms"},"status":400}`))
+	})
+
+	err := createIndex(context.Background(), es, discardLogger, bootstrapOptions{Synonyms: []string{"a =>"}})
+	if eserr.Status(err) != http.StatusBadRequest {
+		t.Errorf("createIndex() = %v, want the 400 of the cluster", err)
+	}
+}
+
+// mappedField returns the mapping of the dotted field path in the mapping
+// built by indexSettings, descending into properties and multi-fields.
+func mappedField(t *testing.T, path string) map[string]interface{} {
+	t.Helper()
+
+	var settings struct {
+		Mappings map[string]interface{} `json:"mappings"`
+	}
+	if err := json.Unmarshal(indexSettings(nil), &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	node := settings.Mappings
+	for _, name := range strings.Split(path, ".") {
+		children, _ := node["properties"].(map[string]interface{})
+		if children[name] == nil {
+			children, _ = node["fields"].(map[string]interface{})
+		}
+		next, ok := children[name].(map[string]interface{})
+		if !ok {
+			t.Fatalf("field %q not mapped", path)
+		}
+		node = next
+	}
+
+	return node
+}
+
+// TestHighlightSubFieldsTermVectors checks the matched_fields the server
+// accepts are mapped as the fast vector highlighter requires.
+func TestHighlightSubFieldsTermVectors(t *testing.T) {
+	for name, subs := range highlightSubFields {
+		for _, field := range append([]string{name}, subs...) {
+			m := mappedField(t, field)
+			if m["type"] != "text" || m["term_vector"] != "with_positions_offsets" {
+				t.Errorf("%s mapped as %v, want a text field with term vectors", field, m)
+			}
+		}
+	}
+}
+
+func TestSeedPeople(t *testing.T) {
+	people, err := seedPeople()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(people) != 4 {
+		t.Fatalf("got %d people, want 4", len(people))
+	}
+	if p := people[0]; p.ID != "1" || p.LastName != "Franssen" || p.Address == nil || p.Address.City != "Amsterdam" {
+		t.Errorf("unexpected first person %+v", p)
+	}
+	if p := people[3]; p.ID != "4" || p.Address != nil {
+		t.Errorf("unexpected last person %+v", p)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errBreakerOpen is returned for requests short-circuited by an open breaker.
+var errBreakerOpen = errors.New("elasticsearch circuit breaker is open")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// esBreakers holds the breaker of every client built by newEsClient, for
+// /healthz.
+var esBreakers []*breaker
+
+// breaker is an http.RoundTripper that stops sending requests to the cluster
+// after threshold consecutive failures (transport errors or 5xx responses).
+// While open, requests fail immediately with errBreakerOpen. After cooldown a
+// single probe request is let through: it closes the breaker on success and
+// reopens it on failure.
+type breaker struct {
+	next      http.RoundTripper
+	cluster   string
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(next http.RoundTripper, addresses []string, threshold int,
+	cooldown time.Duration) *breaker {
+
+	return &breaker{
+		next:      next,
+		cluster:   strings.Join(addresses, ","),
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     breakerClosed,
+	}
+}
+
+func (b *breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	allowed, probe := b.allow()
+	if !allowed {
+		return nil, errBreakerOpen
+	}
+
+	res, err := b.next.RoundTrip(req)
+	// A client giving up says nothing about the health of the cluster, but
+	// a probe without an outcome must make way for the next one.
+	if req.Context().Err() == nil {
+		b.record(err == nil && res.StatusCode < http.StatusInternalServerError)
+	} else if probe {
+		b.releaseProbe()
+	}
+
+	return res, err
+}
+
+// allow reports whether a request may be sent, and whether it is the probe
+// of a half-open breaker.
+func (b *breaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		return true, true
+	case breakerHalfOpen:
+		// Only the probe goes through until it has an outcome.
+		return false, false
+	}
+
+	return true, false
+}
+
+// releaseProbe reopens a half-open breaker whose probe was cancelled. The
+// cooldown has already elapsed, so the next request becomes the probe.
+func (b *breaker) releaseProbe() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+func (b *breaker) record(ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ok {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+type breakerState struct {
+	Cluster  string `json:"cluster"`
+	State    string `json:"state"`
+	Failures int    `json:"consecutive_failures"`
+}
+
+func (b *breaker) snapshot() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return breakerState{Cluster: b.cluster, State: b.state, Failures: b.failures}
+}
+
+func breakerStates() []breakerState {
+	states := make([]breakerState, 0, len(esBreakers))
+	for _, b := range esBreakers {
+		states = append(states, b.snapshot())
+	}
+
+	return states
+}
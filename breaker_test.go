@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+// statusTransport answers every request with the current status, or with
+// the context error of cancelled requests.
+func statusTransport(status *int) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return &http.Response{StatusCode: *status, Body: http.NoBody}, nil
+	})
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	b := newBreaker(statusTransport(&status), []string{"es"}, 2, 0)
+	req, _ := http.NewRequest(http.MethodGet, "http://es/", nil)
+
+	for i := 0; i < 2; i++ {
+		if _, err := b.RoundTrip(req); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	if got := b.snapshot().State; got != breakerOpen {
+		t.Fatalf("state after 2 failures = %s, want %s", got, breakerOpen)
+	}
+
+	status = http.StatusOK
+	if _, err := b.RoundTrip(req); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if got := b.snapshot().State; got != breakerClosed {
+		t.Errorf("state after a successful probe = %s, want %s", got, breakerClosed)
+	}
+}
+
+func TestBreakerShortCircuits(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	b := newBreaker(statusTransport(&status), []string{"es"}, 1, time.Hour)
+	req, _ := http.NewRequest(http.MethodGet, "http://es/", nil)
+
+	b.RoundTrip(req)
+	if _, err := b.RoundTrip(req); !errors.Is(err, errBreakerOpen) {
+		t.Errorf("RoundTrip() of an open breaker = %v, want %v", err, errBreakerOpen)
+	}
+}
+
+// TestBreakerCancelledProbe checks a cancelled probe doesn't leave the
+// breaker half-open, blocking every later request.
+func TestBreakerCancelledProbe(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	b := newBreaker(statusTransport(&status), []string{"es"}, 1, 0)
+	req, _ := http.NewRequest(http.MethodGet, "http://es/", nil)
+	b.RoundTrip(req)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.RoundTrip(req.WithContext(ctx)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("cancelled probe: %v", err)
+	}
+	if got := b.snapshot().State; got != breakerOpen {
+		t.Fatalf("state after a cancelled probe = %s, want %s", got, breakerOpen)
+	}
+
+	status = http.StatusOK
+	if _, err := b.RoundTrip(req); err != nil {
+		t.Fatalf("next probe: %v", err)
+	}
+	if got := b.snapshot().State; got != breakerClosed {
+		t.Errorf("state after the next probe = %s, want %s", got, breakerClosed)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
+)
+
+// bulkResponse is the subset of a bulk response needed to find failed items.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		ID     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// bulkCreate indexes people into idx with bulk requests of at most batchSize
+// documents, sending up to workers of them concurrently. Once a batch fails
+// no new batches are started, but the ones in flight are allowed to finish.
+// The first failure is returned together with the number of failed batches.
+func bulkCreate(ctx context.Context, es *elasticsearch.Client, idx string,
+	people []*Person, batchSize, workers int) error {
+
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	batches := make(chan []*Person)
+	stop := make(chan struct{})
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		failed   int
+		total    int
+		once     sync.Once
+		wg       sync.WaitGroup
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := sendBulk(ctx, es, idx, batch); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					failed++
+					mu.Unlock()
+					once.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+send:
+	for start := 0; start < len(people); start += batchSize {
+		end := start + batchSize
+		if end > len(people) {
+			end = len(people)
+		}
+
+		select {
+		case batches <- people[start:end]:
+			total++
+		case <-stop:
+			break send
+		case <-ctx.Done():
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = ctx.Err()
+			}
+			mu.Unlock()
+			break send
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	if firstErr != nil {
+		if failed == 0 {
+			return firstErr
+		}
+		return fmt.Errorf("%d of %d bulk requests failed, first: %w", failed, total, firstErr)
+	}
+
+	return nil
+}
+
+// sendBulk creates the batch of people with a single bulk request.
+func sendBulk(ctx context.Context, es *elasticsearch.Client, idx string, batch []*Person) error {
+	var body bytes.Buffer
+	enc := ndjson.NewWriter(&body)
+	for _, p := range batch {
+		meta := map[string]interface{}{"create": map[string]string{"_id": p.ID}}
+		if err := enc.Encode(meta); err != nil {
+			return err
+		}
+		if err := enc.Encode(p); err != nil {
+			return err
+		}
+	}
+
+	res, err := esapi.BulkRequest{Index: idx, Body: &body}.Do(ctx, es)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return err
+	}
+
+	var result bulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Errors {
+		return nil
+	}
+
+	var reasons []string
+	for _, item := range result.Items {
+		for _, op := range item {
+			if op.Status >= 300 {
+				reasons = append(reasons, fmt.Sprintf("%s: %s: %s", op.ID, op.Error.Type, op.Error.Reason))
+			}
+		}
+	}
+
+	return fmt.Errorf("bulk create of %d documents: %d failed: %s",
+		len(batch), len(reasons), strings.Join(reasons, "; "))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// camelCase reports whether the client asked for camelCase field names, with
+// case=camel or an Accept header such as application/json; case=camel.
+func camelCase(r *http.Request) bool {
+	if r.URL.Query().Get("case") == "camel" {
+		return true
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "application/json" && params["case"] == "camel" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// camelWriter rewrites the snake_case object keys of the compact JSON
+// streaming through it to camelCase, e.g. first_name to firstName. Leading
+// underscores, as in _seq_no, are kept. Values are left untouched, so the
+// stored documents keep their shape.
+type camelWriter struct {
+	w io.Writer
+
+	// containers holds the open { and [ from the outermost.
+	containers []byte
+	expectKey  bool
+	inString   bool
+	inKey      bool
+	escaped    bool
+	keyStart   bool
+	upperNext  bool
+}
+
+func (c *camelWriter) Write(b []byte) (int, error) {
+	var out strings.Builder
+	for _, ch := range b {
+		if c.inString {
+			c.writeStringByte(&out, ch)
+			continue
+		}
+
+		switch ch {
+		case '{', '[':
+			c.containers = append(c.containers, ch)
+			c.expectKey = ch == '{'
+		case '}', ']':
+			if n := len(c.containers); n > 0 {
+				c.containers = c.containers[:n-1]
+			}
+			c.expectKey = false
+		case ',':
+			c.expectKey = len(c.containers) > 0 && c.containers[len(c.containers)-1] == '{'
+		case '"':
+			c.inString = true
+			c.inKey = c.expectKey
+			c.keyStart = true
+			c.expectKey = false
+		}
+		out.WriteByte(ch)
+	}
+
+	if _, err := io.WriteString(c.w, out.String()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (c *camelWriter) writeStringByte(out *strings.Builder, ch byte) {
+	switch {
+	case c.escaped:
+		c.escaped = false
+	case ch == '\\':
+		c.escaped = true
+	case ch == '"':
+		c.inString = false
+		c.upperNext = false
+	case c.inKey && ch == '_' && !c.keyStart:
+		c.upperNext = true
+		return
+	case c.inKey && c.upperNext && 'a' <= ch && ch <= 'z':
+		ch -= 'a' - 'A'
+	}
+
+	if ch != '_' {
+		c.keyStart = false
+	}
+	c.upperNext = false
+	out.WriteByte(ch)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+)
+
+// gzipTransport compresses request bodies sent to Elasticsearch. The pinned
+// client predates Config.CompressRequestBody, so compression is applied at
+// the transport level instead.
+type gzipTransport struct {
+	next http.RoundTripper
+}
+
+func (t gzipTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return t.next.RoundTrip(req)
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.Copy(zw, req.Body); err != nil {
+		req.Body.Close()
+		return nil, err
+	}
+	req.Body.Close()
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	compressed := buf.Bytes()
+	zreq := req.Clone(req.Context())
+	zreq.Header.Set("Content-Encoding", "gzip")
+	zreq.ContentLength = int64(len(compressed))
+	zreq.Body = io.NopCloser(bytes.NewReader(compressed))
+	zreq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(compressed)), nil
+	}
+
+	return t.next.RoundTrip(zreq)
+}
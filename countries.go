@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+const (
+	defaultCountryBuckets = 10
+	// maxCountryBuckets mirrors the search.max_buckets default.
+	maxCountryBuckets = 10000
+)
+
+type countryCount struct {
+	Country string `json:"country"`
+	Count   int    `json:"count"`
+}
+
+type countriesResponse struct {
+	Countries []countryCount `json:"countries"`
+	// AfterKey is the cursor of the next page of a composite aggregation,
+	// omitted on the last page.
+	AfterKey string `json:"after_key,omitempty"`
+}
+
+// countriesHandler counts people per country. By default it returns the size
+// most common countries with a terms aggregation; with composite=true it
+// pages through all countries in alphabetical order with a composite
+// aggregation, passing the returned after_key back as after.
+func countriesHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		q := r.URL.Query()
+		size, err := intParam(r, "size", defaultCountryBuckets)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if size > maxCountryBuckets {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("size must be at most %d", maxCountryBuckets))
+			return
+		}
+
+		composite := false
+		if v := q.Get("composite"); v != "" {
+			if composite, err = strconv.ParseBool(v); err != nil {
+				writeError(w, http.StatusBadRequest, "composite must be true or false")
+				return
+			}
+		}
+		after := q.Get("after")
+		if after != "" && !composite {
+			writeError(w, http.StatusBadRequest, "after requires composite=true")
+			return
+		}
+		minCount, err := intParam(r, "min_count", 1)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if minCount > 1 && composite {
+			// Composite aggregations have no min_doc_count.
+			writeError(w, http.StatusBadRequest, "min_count is not supported with composite=true")
+			return
+		}
+
+		res, err := es.Search(
+			es.Search.WithContext(r.Context()),
+			es.Search.WithIndex(peopleIndex),
+			es.Search.WithBody(buildCountriesQuery(size, minCount, composite, after, includeAll(r))),
+		)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		var body struct {
+			Aggregations struct {
+				Countries struct {
+					AfterKey *struct {
+						Country string `json:"country"`
+					} `json:"after_key"`
+					Buckets []struct {
+						Key      json.RawMessage `json:"key"`
+						DocCount int             `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"countries"`
+			} `json:"aggregations"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		agg := body.Aggregations.Countries
+		out := countriesResponse{Countries: make([]countryCount, 0, len(agg.Buckets))}
+		for _, b := range agg.Buckets {
+			// Terms buckets are keyed by the value, composite ones by an
+			// object holding the value of every source.
+			var country string
+			if composite {
+				var key struct {
+					Country string `json:"country"`
+				}
+				json.Unmarshal(b.Key, &key)
+				country = key.Country
+			} else {
+				json.Unmarshal(b.Key, &country)
+			}
+			out.Countries = append(out.Countries, countryCount{Country: country, Count: b.DocCount})
+		}
+		// A full page may be followed by more buckets; a short one is last.
+		if agg.AfterKey != nil && len(agg.Buckets) == size {
+			out.AfterKey = agg.AfterKey.Country
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+func buildCountriesQuery(size, minCount int, composite bool, after string, all bool) io.Reader {
+	agg := map[string]interface{}{
+		"terms": map[string]interface{}{"field": "country.keyword", "size": size, "min_doc_count": minCount},
+	}
+	if composite {
+		comp := map[string]interface{}{
+			"sources": []interface{}{
+				map[string]interface{}{
+					"country": map[string]interface{}{"terms": map[string]string{"field": "country.keyword"}},
+				},
+			},
+			"size": size,
+		}
+		if after != "" {
+			comp["after"] = map[string]string{"country": after}
+		}
+		agg = map[string]interface{}{"composite": comp}
+	}
+
+	body := map[string]interface{}{
+		"size":  0,
+		"query": scoped(map[string]interface{}{"match_all": map[string]interface{}{}}, all),
+		"aggs":  map[string]interface{}{"countries": agg},
+	}
+
+	payload, _ := json.Marshal(body)
+	return bytes.NewReader(payload)
+}
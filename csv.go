@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+var csvHeader = []string{
+	"id", "title", "first_name", "last_name", "email", "country",
+	"address.street", "address.city", "address.postcode",
+}
+
+// acceptsCSV reports whether the client asked for CSV in its Accept header.
+func acceptsCSV(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == "text/csv" && params["q"] != "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// encodeSearchCSV writes the search results as CSV, one row per person,
+// preceded by a header row.
+func encodeSearchCSV(w io.Writer, res searchResponse) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, result := range res.Results {
+		if result.Person == nil {
+			continue
+		}
+		if err := cw.Write(personRecord(result.Person)); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func personRecord(p *Person) []string {
+	var a Address
+	if p.Address != nil {
+		a = *p.Address
+	}
+
+	return []string{
+		p.ID, p.Title, p.FirstName, p.LastName, p.Email, p.Country,
+		a.Street, a.City, a.Postcode,
+	}
+}
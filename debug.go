@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+)
+
+// secretFields matches JSON string values of credential-like keys.
+var secretFields = regexp.MustCompile(`("(?i:password|passwd|secret|token|api_key|apikey)"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+
+// debugTransport logs the bodies of requests sent to Elasticsearch and of
+// their responses, truncated to max bytes.
+type debugTransport struct {
+	next   http.RoundTripper
+	logger *log.Logger
+	max    int
+}
+
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := requestID(req.Context())
+
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	t.logger.Printf("es request [%s]: %s %s %s", id, req.Method, redactURL(req), t.truncate(body))
+
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		t.logger.Printf("es response [%s]: %v", id, err)
+		return res, err
+	}
+
+	res.Body = &capturingBody{
+		ReadCloser: res.Body,
+		max:        t.max,
+		done: func(captured []byte) {
+			t.logger.Printf("es response [%s]: %s %s", id, res.Status, t.truncate(captured))
+		},
+	}
+
+	return res, nil
+}
+
+func (t debugTransport) truncate(b []byte) string {
+	s := secretFields.ReplaceAllString(string(b), `$1"***"`)
+	if len(s) > t.max {
+		return s[:t.max] + "...(truncated)"
+	}
+
+	return s
+}
+
+func redactURL(req *http.Request) string {
+	u := *req.URL
+	if u.User != nil {
+		u.User = nil
+		return "***@" + u.String()
+	}
+
+	return u.String()
+}
+
+// capturingBody keeps the first max bytes read from the body and hands them
+// to done when the body is closed.
+type capturingBody struct {
+	io.ReadCloser
+	max  int
+	buf  bytes.Buffer
+	done func([]byte)
+}
+
+func (c *capturingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if room := c.max + 1 - c.buf.Len(); room > 0 {
+		if room > n {
+			room = n
+		}
+		c.buf.Write(p[:room])
+	}
+
+	return n, err
+}
+
+func (c *capturingBody) Close() error {
+	if c.done != nil {
+		c.done(c.buf.Bytes())
+		c.done = nil
+	}
+
+	return c.ReadCloser.Close()
+}
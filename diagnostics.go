@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+const diagnosticsTimeout = 10 * time.Second
+
+// diagnosticsSection holds the outcome of one part of the report: its data,
+// or the error that prevented gathering it.
+type diagnosticsSection struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error string          `json:"error,omitempty"`
+}
+
+type diagnosticsResponse struct {
+	Version  string                        `json:"version"`
+	Config   map[string]string             `json:"config"`
+	Sections map[string]diagnosticsSection `json:"sections"`
+}
+
+// diagnosticsHandler bundles cluster health, index stats and mapping with the
+// app version and redacted config into one report for support tickets. The
+// cluster calls run concurrently; a failed one is reported in its section
+// without failing the report.
+func diagnosticsHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), diagnosticsTimeout)
+		defer cancel()
+
+		calls := map[string]esapi.Request{
+			"cluster_health": esapi.ClusterHealthRequest{},
+			"index_stats":    esapi.IndicesStatsRequest{Index: []string{peopleIndex}},
+			"mapping":        esapi.IndicesGetMappingRequest{Index: []string{peopleIndex}},
+		}
+
+		var (
+			mu sync.Mutex
+			wg sync.WaitGroup
+		)
+		out := diagnosticsResponse{
+			Version:  appVersion,
+			Config:   redactedConfig(),
+			Sections: make(map[string]diagnosticsSection, len(calls)),
+		}
+		for name, req := range calls {
+			wg.Add(1)
+			go func(name string, req esapi.Request) {
+				defer wg.Done()
+				section := diagnose(ctx, es, req)
+				mu.Lock()
+				out.Sections[name] = section
+				mu.Unlock()
+			}(name, req)
+		}
+		wg.Wait()
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
+
+func diagnose(ctx context.Context, es *elasticsearch.Client, req esapi.Request) diagnosticsSection {
+	res, err := req.Do(ctx, es)
+	if err != nil {
+		return diagnosticsSection{Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return diagnosticsSection{Error: err.Error()}
+	}
+
+	var data json.RawMessage
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return diagnosticsSection{Error: err.Error()}
+	}
+
+	return diagnosticsSection{Data: data}
+}
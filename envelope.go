@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// envelopeMediaType is the Accept variant asking for an enveloped response.
+const envelopeMediaType = "application/vnd.es-demo.envelope+json"
+
+type envelope struct {
+	Data json.RawMessage `json:"data"`
+	Meta envelopeMeta    `json:"meta"`
+}
+
+type envelopeMeta struct {
+	RequestID string `json:"request_id,omitempty"`
+	Took      *int   `json:"took,omitempty"`
+	Total     *int   `json:"total,omitempty"`
+}
+
+// acceptsEnvelope reports whether the request asks for an enveloped
+// response through its Accept header.
+func acceptsEnvelope(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == envelopeMediaType && params["q"] != "0" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// withEnvelope wraps successful JSON responses as {"data": ..., "meta": ...},
+// with the request ID and the took and total of the response, if any, in
+// meta. It applies to every request when enabled, and otherwise to requests
+// accepting envelopeMediaType. Errors and streamed NDJSON, CSV or event
+// responses are left as they are.
+func withEnvelope(enabled bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled && !acceptsEnvelope(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ew := &envelopeWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		ew.finish(r)
+	})
+}
+
+// envelopeWriter buffers a successful JSON response until the handler
+// returns, and passes any other response through.
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if status >= 200 && status < 300 && status != http.StatusNoContent && mediaType == "application/json" {
+		w.buffering = true
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *envelopeWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.buf.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *envelopeWriter) Flush() {
+	if w.buffering {
+		return
+	}
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		fl.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish writes the buffered response in its envelope. A body that isn't
+// valid JSON, e.g. one cut short by an error, is written unchanged.
+func (w *envelopeWriter) finish(r *http.Request) {
+	if !w.buffering {
+		return
+	}
+	w.Header().Del("Content-Length")
+
+	data := w.buf.Bytes()
+	if !json.Valid(data) {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(data)
+		return
+	}
+
+	meta := envelopeMeta{RequestID: requestID(r.Context())}
+	var counts struct {
+		Took  *int `json:"took"`
+		Total *int `json:"total"`
+	}
+	if json.Unmarshal(data, &counts) == nil {
+		meta.Took = counts.Took
+		meta.Total = counts.Total
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+	json.NewEncoder(jsonOutput(w.ResponseWriter, r)).Encode(envelope{Data: data, Meta: meta})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEnvelope(t *testing.T) {
+	h := withRequestID(withEnvelope(false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"took": 3, "total": 7, "results": []string{}})
+	})))
+
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", `{"results":[],"took":3,"total":7}` + "\n"},
+		{envelopeMediaType, `{"data":{"results":[],"took":3,"total":7},"meta":{"request_id":"id","took":3,"total":7}}` + "\n"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/search", nil)
+		r.Header.Set("Accept", tt.accept)
+		r.Header.Set("X-Request-Id", "id")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("Accept %q: body = %s, want %s", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestEnvelopeErrorsUnwrapped(t *testing.T) {
+	h := withEnvelope(true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusBadRequest, "bad")
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+	if got, want := rec.Body.String(), `{"error":"bad"}`+"\n"; got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
+
+func TestEnvelopeWriteDeadline(t *testing.T) {
+	assertDeadline(t, withEnvelope(true, http.HandlerFunc(deadlineHandler)))
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
+)
+
+// newEsClient builds the Elasticsearch client. Everything that depends on the
+// cluster's major version is decided here, so the rest of the code talks to
+// 7.x and 8.x clusters through the same client.
+func newEsClient(logger *log.Logger, addresses []string) *elasticsearch.Client {
+	cfg := elasticsearch.Config{
+		Addresses: addresses,
+		Username:  esUsername,
+		Password:  esPassword,
+		Selector:  nodeSelector(esSelector, addresses),
+		// Retries are made by withRetry, which knows about the breaker and
+		// the request deadline; the client's own would multiply them.
+		DisableRetry: true,
+	}
+
+	transport, err := baseTransport()
+	if err != nil {
+		logger.Println(err)
+		panic(err)
+	}
+	if breakerThreshold > 0 {
+		b := newBreaker(transport, addresses, breakerThreshold, breakerCooldown)
+		esBreakers = append(esBreakers, b)
+		transport = b
+	}
+	transport = traceTransport{next: transport}
+	if esUserAgent != "" {
+		transport = userAgentTransport{next: transport, userAgent: esUserAgent}
+	}
+	if esVersion >= 8 {
+		transport = compatTransport{next: transport}
+	}
+	if esCompress {
+		transport = gzipTransport{next: transport}
+	}
+	if debugBodies {
+		transport = debugTransport{next: transport, logger: logger, max: debugBodiesMax}
+	}
+	if logESNode || debugESNode {
+		transport = nodeTransport{next: transport, logger: logger, log: logESNode}
+	}
+	var timings *nodeTimings
+	if esMetrics {
+		timings = newNodeTimings(transport)
+		transport = timings
+		cfg.EnableMetrics = true
+	}
+	cfg.Transport = transport
+
+	client, err := elasticsearch.NewClient(cfg)
+	if err != nil {
+		logger.Println(err)
+		panic(err)
+	}
+	if timings != nil {
+		esNodeTimings[client] = timings
+	}
+
+	return client
+}
+
+const (
+	selectorRoundRobin = "round-robin"
+	selectorRandom     = "random"
+	selectorFirst      = "first"
+)
+
+// nodeSelector returns the estransport.Selector picking the node of each
+// request, or nil for the client's default round-robin.
+func nodeSelector(name string, addresses []string) estransport.Selector {
+	switch name {
+	case selectorRandom:
+		return &randomSelector{rnd: rand.New(rand.NewSource(rand.Int63()))}
+	case selectorFirst:
+		rank := make(map[string]int, len(addresses))
+		for i, addr := range addresses {
+			rank[strings.TrimSuffix(addr, "/")] = i
+		}
+		return firstSelector{rank: rank}
+	}
+
+	return nil
+}
+
+// randomSelector picks a live node at random, spreading the load of many
+// server instances started at once instead of moving through the nodes in
+// lockstep.
+type randomSelector struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func (s *randomSelector) Select(conns []*estransport.Connection) (*estransport.Connection, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("no connection available")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return conns[s.rnd.Intn(len(conns))], nil
+}
+
+// firstSelector sends every request to the first live node in -es-addresses
+// order, so a coordinating-only node listed first takes all the traffic while
+// the others are only used when it is down. The order is kept in rank, as the
+// pool appends resurrected nodes at the end of its list.
+type firstSelector struct {
+	rank map[string]int
+}
+
+func (s firstSelector) Select(conns []*estransport.Connection) (*estransport.Connection, error) {
+	if len(conns) == 0 {
+		return nil, errors.New("no connection available")
+	}
+
+	best := conns[0]
+	for _, c := range conns[1:] {
+		if s.rank[strings.TrimSuffix(c.URL.String(), "/")] < s.rank[strings.TrimSuffix(best.URL.String(), "/")] {
+			best = c
+		}
+	}
+
+	return best, nil
+}
+
+// baseTransport returns the HTTP transport used to reach the cluster,
+// trusting the CA given by -es-ca-cert. Elasticsearch 8 enables TLS with a
+// self-signed CA by default.
+func baseTransport() (http.RoundTripper, error) {
+	if esCACert == "" {
+		return http.DefaultTransport, nil
+	}
+
+	pem, err := os.ReadFile(esCACert)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificates found in " + esCACert)
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = &tls.Config{RootCAs: pool}
+
+	return t, nil
+}
+
+// userAgentTransport identifies the server to the cluster, e.g. in its audit
+// log, replacing the User-Agent set by the client.
+type userAgentTransport struct {
+	next      http.RoundTripper
+	userAgent string
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.userAgent)
+
+	return t.next.RoundTrip(req)
+}
+
+// traceTransport sets the trace headers of the incoming request, kept by
+// withTraceHeaders, on the requests sent to the cluster. Elasticsearch
+// reports X-Opaque-Id in its slowlogs and tasks.
+type traceTransport struct {
+	next http.RoundTripper
+}
+
+func (t traceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	h, ok := req.Context().Value(traceHeadersKey).(http.Header)
+	if !ok || len(h) == 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	for name, values := range h {
+		req.Header[name] = values
+	}
+
+	return t.next.RoundTrip(req)
+}
+
+// compatTransport asks an Elasticsearch 8 cluster to speak the 7.x REST API
+// through compatibility headers, which the v7 client and its esapi requests
+// rely on.
+type compatTransport struct {
+	next http.RoundTripper
+}
+
+func (t compatTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+
+	req.Header.Set("Accept", "application/vnd.elasticsearch+json;compatible-with=7")
+	if req.Body != nil && req.Body != http.NoBody {
+		// The v7 client sends bulk bodies as application/json.
+		if ndjson.IsContentType(req.Header.Get("Content-Type")) || strings.HasSuffix(req.URL.Path, "/_bulk") {
+			req.Header.Set("Content-Type", "application/vnd.elasticsearch+x-ndjson;compatible-with=7")
+		} else {
+			req.Header.Set("Content-Type", "application/vnd.elasticsearch+json;compatible-with=7")
+		}
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,132 @@
+// Package eserr decodes Elasticsearch error responses into typed errors.
+package eserr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// Error is an error reported by Elasticsearch in its standard envelope:
+//
+//	{"error": {"type": "...", "reason": "..."}, "status": 404}
+type Error struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("elasticsearch: %d %s", e.Status, e.Reason)
+	}
+
+	return fmt.Sprintf("elasticsearch: %d %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// FromResponse returns the error carried by res, or nil when res is not an
+// error response. It consumes the response body.
+func FromResponse(res *esapi.Response) error {
+	if !res.IsError() {
+		return nil
+	}
+
+	var body []byte
+	if res.Body != nil {
+		body, _ = io.ReadAll(res.Body)
+	}
+
+	return Decode(res.StatusCode, body)
+}
+
+// Decode parses an Elasticsearch error body returned with the given HTTP
+// status. Besides the standard envelope it understands errors reported as a
+// plain string and bodies without any error, such as a document lookup that
+// found nothing.
+func Decode(status int, body []byte) *Error {
+	e := &Error{Status: status, Reason: http.StatusText(status)}
+
+	var envelope struct {
+		Error  json.RawMessage `json:"error"`
+		Status int             `json:"status"`
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil || len(envelope.Error) == 0 {
+		return e
+	}
+	if envelope.Status != 0 {
+		e.Status = envelope.Status
+	}
+
+	var reason string
+	if err := json.Unmarshal(envelope.Error, &reason); err == nil {
+		e.Reason = reason
+		return e
+	}
+
+	var cause struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(envelope.Error, &cause); err == nil {
+		e.Type = cause.Type
+		if cause.Reason != "" {
+			e.Reason = cause.Reason
+		}
+	}
+
+	return e
+}
+
+// Status returns the HTTP status carried by err, or 500 when err is not an
+// Elasticsearch error.
+func Status(err error) int {
+	var e *Error
+	if errors.As(err, &e) && e.Status != 0 {
+		return e.Status
+	}
+
+	return http.StatusInternalServerError
+}
+
+// IsNotFound reports whether err is a missing document or index.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// IsIndexNotFound reports whether err is caused by a missing index.
+func IsIndexNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Type == "index_not_found_exception"
+}
+
+// IsConflict reports whether err is a version conflict.
+func IsConflict(err error) bool {
+	var e *Error
+	return errors.As(err, &e) &&
+		(e.Status == http.StatusConflict || e.Type == "version_conflict_engine_exception")
+}
+
+// IsUnsupported reports whether err is caused by a feature the cluster does
+// not offer, either missing from its distribution or from its license.
+func IsUnsupported(err error) bool {
+	var e *Error
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	return strings.HasPrefix(e.Reason, "no handler found for uri") ||
+		(e.Type == "security_exception" && strings.Contains(e.Reason, "license"))
+}
+
+// IsUnavailableShards reports whether err is caused by too few active shard
+// copies, e.g. when wait_for_active_shards could not be met in time.
+func IsUnavailableShards(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Type == "unavailable_shards_exception"
+}
@@ -0,0 +1,82 @@
+package eserr
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   Error
+	}{
+		{
+			name:   "standard envelope",
+			status: 404,
+			body:   `{"error":{"root_cause":[],"type":"index_not_found_exception","reason":"no such index [people]"},"status":404}`,
+			want:   Error{Status: 404, Type: "index_not_found_exception", Reason: "no such index [people]"},
+		},
+		{
+			name:   "malformed query",
+			status: 400,
+			body:   `{"error":{"type":"parsing_exception","reason":"unknown query [mach]"},"status":400}`,
+			want:   Error{Status: 400, Type: "parsing_exception", Reason: "unknown query [mach]"},
+		},
+		{
+			name:   "plain string error",
+			status: 400,
+			body:   `{"error":"no handler found for uri [/_foo] and method [GET]"}`,
+			want:   Error{Status: 400, Reason: "no handler found for uri [/_foo] and method [GET]"},
+		},
+		{
+			name:   "document not found",
+			status: 404,
+			body:   `{"_index":"people","_id":"1","found":false}`,
+			want:   Error{Status: 404, Reason: "Not Found"},
+		},
+		{
+			name:   "proxy error page",
+			status: 502,
+			body:   `<html>Bad Gateway</html>`,
+			want:   Error{Status: 502, Reason: "Bad Gateway"},
+		},
+		{
+			name:   "envelope status wins",
+			status: 500,
+			body:   `{"error":{"type":"version_conflict_engine_exception","reason":"conflict"},"status":409}`,
+			want:   Error{Status: 409, Type: "version_conflict_engine_exception", Reason: "conflict"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Decode(tt.status, []byte(tt.body)); *got != tt.want {
+				t.Errorf("Decode() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	indexNotFound := fmt.Errorf("search: %w", &Error{Status: 404, Type: "index_not_found_exception"})
+	conflict := &Error{Status: 409, Type: "version_conflict_engine_exception"}
+	unsupported := &Error{Status: 400, Reason: "no handler found for uri [/_ilm/policy/people]"}
+
+	if !IsNotFound(indexNotFound) || !IsIndexNotFound(indexNotFound) {
+		t.Error("wrapped index_not_found_exception not recognized")
+	}
+	if IsIndexNotFound(&Error{Status: 404}) {
+		t.Error("missing document reported as missing index")
+	}
+	if !IsConflict(conflict) || IsConflict(indexNotFound) {
+		t.Error("IsConflict mismatch")
+	}
+	if !IsUnsupported(unsupported) || IsUnsupported(conflict) {
+		t.Error("IsUnsupported mismatch")
+	}
+	if got := Status(fmt.Errorf("dial tcp: connection refused")); got != http.StatusInternalServerError {
+		t.Errorf("Status() of a transport error = %d, want 500", got)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+)
+
+// esNodeTimings holds the per-node response times of every client built by
+// newEsClient, so the primary and fallback clusters are reported apart. It
+// is only filled when -es-metrics is enabled.
+var esNodeTimings = map[*elasticsearch.Client]*nodeTimings{}
+
+type nodeTiming struct {
+	Requests  int     `json:"requests"`
+	Failures  int     `json:"failures"`
+	AverageMs float64 `json:"avg_ms"`
+	MaxMs     float64 `json:"max_ms"`
+
+	total time.Duration
+}
+
+// nodeTimings is an http.RoundTripper that measures each request sent to the
+// cluster, grouped by node host.
+type nodeTimings struct {
+	next http.RoundTripper
+
+	mu    sync.Mutex
+	nodes map[string]*nodeTiming
+}
+
+func newNodeTimings(next http.RoundTripper) *nodeTimings {
+	return &nodeTimings{next: next, nodes: make(map[string]*nodeTiming)}
+}
+
+func (t *nodeTimings) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n, ok := t.nodes[req.URL.Host]
+	if !ok {
+		n = &nodeTiming{}
+		t.nodes[req.URL.Host] = n
+	}
+
+	n.Requests++
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		n.Failures++
+	}
+	n.total += elapsed
+	n.AverageMs = float64(n.total) / float64(n.Requests) / float64(time.Millisecond)
+	if ms := float64(elapsed) / float64(time.Millisecond); ms > n.MaxMs {
+		n.MaxMs = ms
+	}
+
+	return res, err
+}
+
+func (t *nodeTimings) snapshot() map[string]nodeTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nodes := make(map[string]nodeTiming, len(t.nodes))
+	for host, n := range t.nodes {
+		nodes[host] = *n
+	}
+
+	return nodes
+}
+
+type clusterMetrics struct {
+	Client estransport.Metrics   `json:"client"`
+	Nodes  map[string]nodeTiming `json:"nodes"`
+}
+
+type esMetricsResponse struct {
+	clusterMetrics
+	// Fallback reports the -es-addresses-fallback cluster, if any.
+	Fallback *clusterMetrics `json:"fallback,omitempty"`
+}
+
+// clientMetrics returns the metrics of es, or false when they are not
+// collected.
+func clientMetrics(es *elasticsearch.Client) (clusterMetrics, bool) {
+	timings, ok := esNodeTimings[es]
+	if !ok {
+		return clusterMetrics{}, false
+	}
+	metrics, err := es.Metrics()
+	if err != nil {
+		return clusterMetrics{}, false
+	}
+
+	return clusterMetrics{Client: metrics, Nodes: timings.snapshot()}, true
+}
+
+func esMetricsHandler(logger *log.Logger, reads *failover) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		primary, ok := clientMetrics(reads.primary)
+		if !ok {
+			writeError(w, http.StatusNotFound, "client metrics are disabled, start with -es-metrics")
+			return
+		}
+
+		out := esMetricsResponse{clusterMetrics: primary}
+		if reads.fallback != nil {
+			if fallback, ok := clientMetrics(reads.fallback); ok {
+				out.Fallback = &fallback
+			}
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestESMetricsPerCluster checks the node timings of the primary and
+// fallback clients are kept and reported apart.
+func TestESMetricsPerCluster(t *testing.T) {
+	defer func(saved bool) { esMetrics = saved }(esMetrics)
+	esMetrics = true
+
+	var hosts []string
+	for range [2]struct{}{} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{}`))
+		}))
+		defer srv.Close()
+		hosts = append(hosts, srv.URL)
+	}
+	primary := newEsClient(discardLogger, hosts[:1])
+	fallback := newEsClient(discardLogger, hosts[1:])
+	defer delete(esNodeTimings, primary)
+	defer delete(esNodeTimings, fallback)
+
+	res, err := primary.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	rec := httptest.NewRecorder()
+	reads := &failover{primary: primary, fallback: fallback}
+	esMetricsHandler(discardLogger, reads)(rec, httptest.NewRequest(http.MethodGet, "/es-metrics", nil))
+
+	var out struct {
+		Nodes    map[string]nodeTiming `json:"nodes"`
+		Fallback *struct {
+			Nodes map[string]nodeTiming `json:"nodes"`
+		} `json:"fallback"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatalf("%s: %v", rec.Body, err)
+	}
+	if len(out.Nodes) != 1 {
+		t.Errorf("primary nodes = %v, want the one queried", out.Nodes)
+	}
+	if out.Fallback == nil || len(out.Fallback.Nodes) != 0 {
+		t.Errorf("fallback = %+v, want no timings", out.Fallback)
+	}
+}
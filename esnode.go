@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// nodeTransport records the Elasticsearch node that answered each request.
+// Elastic Cloud names the instance in X-Found-Handling-Instance; otherwise the
+// node is the host the client selected.
+type nodeTransport struct {
+	next   http.RoundTripper
+	logger *log.Logger
+	log    bool
+}
+
+func (t nodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	node := res.Header.Get("X-Found-Handling-Instance")
+	if node == "" {
+		node = req.URL.Host
+	}
+	if t.log {
+		t.logger.Printf("es node [%s]: %s %s served by %s",
+			requestID(req.Context()), req.Method, req.URL.Path, node)
+	}
+	if nodes, ok := req.Context().Value(esNodesKey).(*servedNodes); ok {
+		nodes.add(node)
+	}
+
+	return res, nil
+}
+
+// servedNodes collects the nodes that answered the Elasticsearch requests
+// made for one incoming request.
+type servedNodes struct {
+	mu    sync.Mutex
+	nodes []string
+}
+
+func (s *servedNodes) add(node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, n := range s.nodes {
+		if n == node {
+			return
+		}
+	}
+	s.nodes = append(s.nodes, node)
+}
+
+func (s *servedNodes) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return strings.Join(s.nodes, ", ")
+}
+
+// withESNode reports the nodes that served a request in the X-ES-Node
+// response header. It is a no-op unless enabled.
+func withESNode(enabled bool, next http.Handler) http.Handler {
+	if !enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nodes := &servedNodes{}
+		ctx := context.WithValue(r.Context(), esNodesKey, nodes)
+		next.ServeHTTP(&nodeHeaderWriter{ResponseWriter: w, nodes: nodes}, r.WithContext(ctx))
+	})
+}
+
+// nodeHeaderWriter sets X-ES-Node when the handler starts its response,
+// after it has queried the cluster.
+type nodeHeaderWriter struct {
+	http.ResponseWriter
+	nodes       *servedNodes
+	wroteHeader bool
+}
+
+func (w *nodeHeaderWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if nodes := w.nodes.String(); nodes != "" {
+			w.Header().Set("X-ES-Node", nodes)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *nodeHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *nodeHeaderWriter) Flush() {
+	if fl, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.WriteHeader(http.StatusOK)
+		}
+		fl.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *nodeHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestESNodeWriteDeadline(t *testing.T) {
+	assertDeadline(t, withESNode(true, http.HandlerFunc(deadlineHandler)))
+}
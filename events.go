@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+type clusterHealth struct {
+	Status           string `json:"status"`
+	NumberOfNodes    int    `json:"number_of_nodes"`
+	ActiveShards     int    `json:"active_shards"`
+	UnassignedShards int    `json:"unassigned_shards"`
+	Error            string `json:"error,omitempty"`
+}
+
+// healthEventsHandler streams cluster health as server-sent events, pushing
+// an event whenever the polled health changes.
+func healthEventsHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeError(w, http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+
+		// The stream outlives the server's write timeout.
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			logger.Println("health events: cannot lift the write deadline:", err)
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		ticker := time.NewTicker(healthInterval)
+		defer ticker.Stop()
+
+		var last clusterHealth
+		for first := true; ; first = false {
+			health := pollClusterHealth(r.Context(), es)
+			if r.Context().Err() != nil {
+				return
+			}
+
+			if first || health != last {
+				data, _ := json.Marshal(health)
+				fmt.Fprintf(w, "event: health\ndata: %s\n\n", data)
+				flusher.Flush()
+				last = health
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func pollClusterHealth(ctx context.Context, es *elasticsearch.Client) clusterHealth {
+	res, err := es.Cluster.Health(es.Cluster.Health.WithContext(ctx))
+	if err != nil {
+		return clusterHealth{Status: "unavailable", Error: err.Error()}
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return clusterHealth{Status: "unavailable", Error: err.Error()}
+	}
+
+	var health clusterHealth
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		return clusterHealth{Status: "unavailable", Error: err.Error()}
+	}
+
+	return health
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
+)
+
+const (
+	exportBatch     = 500
+	exportKeepAlive = time.Minute
+)
+
+// exports tracks the exports in progress by token so they can be cancelled.
+type exports struct {
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func newExports() *exports {
+	return &exports{cancels: make(map[string]context.CancelFunc)}
+}
+
+func (e *exports) add(token string, cancel context.CancelFunc) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.cancels[token] = cancel
+}
+
+func (e *exports) remove(token string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.cancels, token)
+}
+
+// cancel stops the export registered under token, reporting whether there
+// was one.
+func (e *exports) cancel(token string) bool {
+	e.mu.Lock()
+	cancel, ok := e.cancels[token]
+	delete(e.cancels, token)
+	e.mu.Unlock()
+
+	if ok {
+		cancel()
+	}
+
+	return ok
+}
+
+// exportHandler serves GET /export, streaming every person as NDJSON with a
+// scroll, and DELETE /export/{token}, cancelling the export identified by the
+// X-Export-Token header of its response.
+func exportHandler(logger *log.Logger, es *elasticsearch.Client, ex *exports) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		token := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/export"), "/")
+		switch {
+		case token == "" && r.Method == http.MethodGet:
+			exportPeople(w, r, logger, es, ex)
+		case token != "" && r.Method == http.MethodDelete:
+			if !ex.cancel(token) {
+				writeError(w, http.StatusNotFound, "no export in progress with this token")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case token == "":
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			w.Header().Set("Allow", "DELETE")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+func exportPeople(w http.ResponseWriter, r *http.Request, logger *log.Logger,
+	es *elasticsearch.Client, ex *exports) {
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	token := newRequestID()
+	ex.add(token, cancel)
+	defer ex.remove(token)
+
+	query := scoped(map[string]interface{}{"match_all": map[string]interface{}{}}, includeAll(r))
+	var out *ndjson.Writer
+	start := func() {
+		// Exports outlive the server write timeout.
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			logger.Println("export: cannot lift the write deadline:", err)
+		}
+		w.Header().Set("X-Export-Token", token)
+		w.Header().Set("Content-Type", ndjson.ContentType)
+		out = ndjson.NewWriter(flushWriter{w})
+	}
+	emit := func(p *Person) error {
+		if p == nil {
+			return nil
+		}
+		return out.Encode(p)
+	}
+
+	if started, err := scrollPeople(ctx, es, query, start, emit); err != nil {
+		if !started {
+			writeESError(w, err)
+			return
+		}
+		logger.Println("export aborted:", err)
+	}
+}
+
+// scrollPeople pages through the people matching query with a scroll and
+// calls emit with each of them. start is called once the first page has
+// arrived, before emit; started reports whether it was, so the caller knows
+// whether an error can still be answered with a status of its own.
+func scrollPeople(ctx context.Context, es *elasticsearch.Client, query map[string]interface{},
+	start func(), emit func(*Person) error) (started bool, err error) {
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"query": query,
+		"sort":  []string{"_doc"},
+	})
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(peopleIndex),
+		es.Search.WithBody(bytes.NewReader(body)),
+		es.Search.WithSize(exportBatch),
+		es.Search.WithScroll(exportKeepAlive),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	page, err := decodeScrollPage(res)
+	if err != nil {
+		return false, err
+	}
+	scrollID := page.ScrollID
+	defer func() {
+		// The request context may be gone, the scroll must be cleared anyway.
+		if scrollID == "" {
+			return
+		}
+		res, err := es.ClearScroll(
+			es.ClearScroll.WithContext(context.Background()),
+			es.ClearScroll.WithScrollID(scrollID),
+		)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+
+	start()
+	for len(page.Hits.Hits) > 0 {
+		for _, hit := range page.Hits.Hits {
+			if err := emit(hit.Source); err != nil {
+				return true, err
+			}
+		}
+
+		res, err := es.Scroll(
+			es.Scroll.WithContext(ctx),
+			es.Scroll.WithScrollID(scrollID),
+			es.Scroll.WithScroll(exportKeepAlive),
+		)
+		if err == nil {
+			page, err = decodeScrollPage(res)
+		}
+		if err != nil {
+			return true, err
+		}
+		if page.ScrollID != "" {
+			scrollID = page.ScrollID
+		}
+	}
+
+	return true, nil
+}
+
+type scrollPage struct {
+	ScrollID string `json:"_scroll_id"`
+	Hits     struct {
+		Hits []struct {
+			Source *Person `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+func decodeScrollPage(res *esapi.Response) (scrollPage, error) {
+	defer res.Body.Close()
+
+	var page scrollPage
+	if err := eserr.FromResponse(res); err != nil {
+		return page, err
+	}
+	err := json.NewDecoder(res.Body).Decode(&page)
+
+	return page, err
+}
+
+// exportCSVHandler serves GET /export.csv, streaming the people matching the
+// search parameters, or everyone without q or filters, as CSV with the
+// columns listed in fields, all of csvHeader by default. Like GET /export it
+// can be cancelled with DELETE /export/{token}.
+func exportCSVHandler(logger *log.Logger, es *elasticsearch.Client, ex *exports) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		columns, err := csvColumns(r.URL.Query().Get("fields"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sq, err := parseSearchQuery(w, r)
+		if err != nil {
+			writeError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
+		query := scoped(map[string]interface{}{"match_all": map[string]interface{}{}}, sq.IncludeAll)
+		if sq.Text != "" || len(sq.Filters) > 0 {
+			query = queryBody(sq)["query"].(map[string]interface{})
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		token := newRequestID()
+		ex.add(token, cancel)
+		defer ex.remove(token)
+
+		cw := csv.NewWriter(flushWriter{w})
+		rows := 0
+		start := func() {
+			if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+				logger.Println("export: cannot lift the write deadline:", err)
+			}
+			w.Header().Set("X-Export-Token", token)
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.csv"`,
+				peopleIndex, time.Now().UTC().Format("20060102-150405")))
+			cw.Write(selectColumns(csvHeader, columns))
+		}
+		emit := func(p *Person) error {
+			if p == nil {
+				return nil
+			}
+			if err := cw.Write(selectColumns(personRecord(p), columns)); err != nil {
+				return err
+			}
+			if rows++; rows%exportBatch == 0 {
+				cw.Flush()
+			}
+			return cw.Error()
+		}
+
+		started, err := scrollPeople(ctx, es, query, start, emit)
+		if started {
+			cw.Flush()
+			if err == nil {
+				err = cw.Error()
+			}
+		}
+		if err != nil {
+			if !started {
+				writeESError(w, err)
+				return
+			}
+			logger.Println("export aborted:", err)
+		}
+	}
+}
+
+// csvColumns returns the indexes in csvHeader of the comma separated
+// fields, or nil for all of them.
+func csvColumns(fields string) ([]int, error) {
+	if fields == "" {
+		return nil, nil
+	}
+
+	var columns []int
+	for _, name := range strings.Split(fields, ",") {
+		i := 0
+		for i < len(csvHeader) && csvHeader[i] != name {
+			i++
+		}
+		if i == len(csvHeader) {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", name, strings.Join(csvHeader, ", "))
+		}
+		columns = append(columns, i)
+	}
+
+	return columns, nil
+}
+
+// selectColumns returns the values of record at columns, in that order, or
+// record itself when columns is nil.
+func selectColumns(record []string, columns []int) []string {
+	if columns == nil {
+		return record
+	}
+
+	out := make([]string, len(columns))
+	for i, c := range columns {
+		out[i] = record[c]
+	}
+
+	return out
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestExportPeopleSkipsHitsWithoutSource checks the NDJSON export writes no
+// line for a hit without _source, as the CSV export writes no row.
+func TestExportPeopleSkipsHitsWithoutSource(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/people/_search":
+			w.Write([]byte(`{"_scroll_id":"s1","hits":{"hits":[` +
+				`{"_id":"1","_source":{"id":"1","first_name":"Rob"}},{"_id":"2"}]}}`))
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{"_scroll_id":"s1","hits":{"hits":[]}}`))
+		}
+	})
+
+	rec := httptest.NewRecorder()
+	exportPeople(rec, httptest.NewRequest(http.MethodGet, "/export", nil), discardLogger, es, newExports())
+
+	want := `{"id":"1","title":"","first_name":"Rob","last_name":"","email":"","country":""}` + "\n"
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// failover routes reads to a fallback cluster once the primary has failed
+// threshold times in a row. While tripped, the primary is probed in the
+// background and reads return to it as soon as it answers again.
+type failover struct {
+	primary   *elasticsearch.Client
+	fallback  *elasticsearch.Client
+	threshold int
+	logger    *log.Logger
+
+	mu       sync.Mutex
+	failures int
+	tripped  bool
+}
+
+func newFailover(logger *log.Logger, primary, fallback *elasticsearch.Client,
+	threshold int, probeInterval time.Duration) *failover {
+
+	f := &failover{
+		primary:   primary,
+		fallback:  fallback,
+		threshold: threshold,
+		logger:    logger,
+	}
+	if fallback != nil {
+		go f.probe(probeInterval)
+	}
+
+	return f
+}
+
+// client returns the cluster reads should currently be sent to.
+func (f *failover) client() *elasticsearch.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.tripped {
+		return f.fallback
+	}
+
+	return f.primary
+}
+
+// report records the outcome of a read sent to es. A read the client gave up
+// on says nothing about the health of the primary and is not counted.
+func (f *failover) report(es *elasticsearch.Client, res *esapi.Response, err error) {
+	if f.fallback == nil || es != f.primary {
+		return
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil && res.StatusCode < http.StatusInternalServerError {
+		f.failures = 0
+		return
+	}
+
+	f.failures++
+	if !f.tripped && f.failures >= f.threshold {
+		f.tripped = true
+		f.logger.Printf("Primary cluster failed %d times, routing reads to fallback", f.failures)
+	}
+}
+
+func (f *failover) probe(interval time.Duration) {
+	for range time.Tick(interval) {
+		f.mu.Lock()
+		tripped := f.tripped
+		f.mu.Unlock()
+		if !tripped {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		res, err := f.primary.Ping(f.primary.Ping.WithContext(ctx))
+		cancel()
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+		if res.IsError() {
+			continue
+		}
+
+		f.mu.Lock()
+		f.tripped, f.failures = false, 0
+		f.mu.Unlock()
+		f.logger.Println("Primary cluster recovered, routing reads back to it")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+func TestFailoverReport(t *testing.T) {
+	primary, fallback := &elasticsearch.Client{}, &elasticsearch.Client{}
+	failed := &esapi.Response{StatusCode: http.StatusServiceUnavailable}
+
+	tests := []struct {
+		name    string
+		res     *esapi.Response
+		err     error
+		tripped bool
+	}{
+		{"server errors", failed, nil, true},
+		{"transport errors", nil, errors.New("connection refused"), true},
+		{"cancelled reads", nil, fmt.Errorf("read: %w", context.Canceled), false},
+		{"timed out reads", nil, context.DeadlineExceeded, false},
+		{"client errors", &esapi.Response{StatusCode: http.StatusNotFound}, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &failover{primary: primary, fallback: fallback, threshold: 2, logger: discardLogger}
+			f.report(primary, tt.res, tt.err)
+			f.report(primary, tt.res, tt.err)
+			if got := f.client() == fallback; got != tt.tripped {
+				t.Errorf("tripped = %v, want %v", got, tt.tripped)
+			}
+		})
+	}
+}
+
+// TestPersonContextReports checks reads of /people/{id}/context count
+// towards failing over.
+func TestPersonContextReports(t *testing.T) {
+	primary := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	f := &failover{primary: primary, fallback: &elasticsearch.Client{}, threshold: 1, logger: discardLogger}
+
+	rec := httptest.NewRecorder()
+	personContext(rec, httptest.NewRequest(http.MethodGet, "/people/1/context", nil), f, "1")
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	if f.client() == primary {
+		t.Error("failed read of the primary was not reported")
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/rafael-henrique-oliveira/es-demo
 
-go 1.13
+go 1.20
 
 require (
 	github.com/elastic/go-elasticsearch v0.0.0 // indirect
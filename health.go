@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+type healthStatus struct {
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+	// Breakers reports the circuit breaker of each cluster.
+	Breakers []breakerState `json:"breakers,omitempty"`
+}
+
+// draining is set once shutdown starts; /healthz then reports unavailable so
+// load balancers stop routing to the server while in-flight requests
+// complete.
+var draining atomic.Bool
+
+// healthzHandler reports the health cached by checker, or checks the cluster
+// on every probe when checker is nil or has not completed a check yet. Checks
+// run by a probe end with its request.
+func healthzHandler(logger *log.Logger, es *elasticsearch.Client, checker *healthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		result := checker.result()
+		if result == nil {
+			result = checkHealth(r.Context(), es)
+		}
+
+		errs := result.cluster
+		if len(errs) == 0 && r.URL.Query().Get("deep") == "true" {
+			errs = result.index
+		}
+		if draining.Load() {
+			errs = append([]string{"server is shutting down"}, errs...)
+		}
+
+		status := healthStatus{Status: "ok"}
+		code := http.StatusOK
+		if len(errs) > 0 {
+			status = healthStatus{Status: "unavailable", Errors: errs}
+			code = http.StatusServiceUnavailable
+		}
+
+		status.Breakers = breakerStates()
+		writeJSON(w, code, status)
+	}
+}
+
+// healthResult holds the errors of the cluster check and, when the cluster
+// is reachable, of the index check run for deep probes.
+type healthResult struct {
+	cluster []string
+	index   []string
+}
+
+func checkHealth(ctx context.Context, es *elasticsearch.Client) *healthResult {
+	if err := checkCluster(ctx, es); err != nil {
+		return &healthResult{cluster: []string{err.Error()}}
+	}
+
+	return &healthResult{index: checkIndex(ctx, es)}
+}
+
+// healthChecker checks the cluster in the background every interval and
+// keeps the last result, so probes of /healthz don't each reach the cluster.
+type healthChecker struct {
+	es       *elasticsearch.Client
+	interval time.Duration
+	last     atomic.Pointer[healthResult]
+}
+
+// result returns the last health result, or nil before the first check.
+func (c *healthChecker) result() *healthResult {
+	if c == nil {
+		return nil
+	}
+
+	return c.last.Load()
+}
+
+// run checks the cluster until ctx is cancelled.
+func (c *healthChecker) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		c.last.Store(c.check(ctx))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check runs one health check. It is given half the interval, so a cluster
+// that does not answer is reported unavailable before the next check is due.
+func (c *healthChecker) check(ctx context.Context) *healthResult {
+	ctx, cancel := context.WithTimeout(ctx, c.interval/2)
+	defer cancel()
+
+	return checkHealth(ctx, c.es)
+}
+
+func checkCluster(ctx context.Context, es *elasticsearch.Client) error {
+	res, err := es.Ping(es.Ping.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("cluster unreachable: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return fmt.Errorf("cluster unhealthy: %s", res.Status())
+	}
+
+	return nil
+}
+
+// checkIndex verifies the people index, or every index behind the people
+// alias, exists and that its mapping contains
+// every field the search query relies on, including the lowercase sub-fields
+// of the exact-value filters and the phonetic and language sub-fields of
+// -phonetic and -languages.
+func checkIndex(ctx context.Context, es *elasticsearch.Client) []string {
+	exists, err := es.Indices.Exists([]string{peopleIndex}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return []string{fmt.Sprintf("index check failed: %v", err)}
+	}
+	exists.Body.Close()
+
+	if exists.StatusCode == http.StatusNotFound {
+		return []string{fmt.Sprintf("index %q does not exist", peopleIndex)}
+	}
+	if exists.IsError() {
+		return []string{fmt.Sprintf("index check failed: %s", exists.Status())}
+	}
+
+	res, err := es.Indices.GetMapping(
+		es.Indices.GetMapping.WithContext(ctx),
+		es.Indices.GetMapping.WithIndex(peopleIndex),
+	)
+	if err != nil {
+		return []string{fmt.Sprintf("mapping check failed: %v", err)}
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return []string{fmt.Sprintf("mapping check failed: %s", res.Status())}
+	}
+
+	var mappings map[string]struct {
+		Mappings mapping `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&mappings); err != nil {
+		return []string{fmt.Sprintf("mapping check failed: %v", err)}
+	}
+
+	// With people an alias the mappings are keyed by the indices behind it,
+	// each of which is searched.
+	names := make([]string, 0, len(mappings))
+	for name := range mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return []string{fmt.Sprintf("mapping check failed: no index for %q", peopleIndex)}
+	}
+
+	var errs []string
+	for _, index := range names {
+		for _, name := range expectedFields() {
+			if !mappings[index].Mappings.hasField(name) {
+				errs = append(errs, fmt.Sprintf("field %q missing from %q mapping", name, index))
+			}
+		}
+	}
+
+	return errs
+}
+
+// expectedFields lists the mapped fields the search query relies on.
+func expectedFields() []string {
+	var names []string
+	for _, f := range searchFields {
+		names = append(names, f.Name)
+	}
+	for _, name := range lowercaseFields {
+		names = append(names, name)
+	}
+	if phoneticNames {
+		for base := range phoneticFields {
+			names = append(names, base+".phonetic")
+		}
+	}
+	for base := range languageFields {
+		for code := range languages {
+			names = append(names, base+"."+code)
+		}
+	}
+
+	return names
+}
+
+type mapping struct {
+	Properties map[string]mapping `json:"properties"`
+	Fields     map[string]mapping `json:"fields"`
+}
+
+// hasField reports whether the dotted field path exists in the mapping,
+// descending into object properties and multi-fields.
+func (m mapping) hasField(path string) bool {
+	name, rest := path, ""
+	if i := strings.Index(path, "."); i >= 0 {
+		name, rest = path[:i], path[i+1:]
+	}
+
+	child, ok := m.Properties[name]
+	if !ok {
+		child, ok = m.Fields[name]
+	}
+	if !ok {
+		return false
+	}
+	if rest == "" {
+		return true
+	}
+
+	return child.hasField(rest)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+// mappingClient serves the mapping bootstrap creates for the people index.
+func mappingClient(t *testing.T) *elasticsearch.Client {
+	t.Helper()
+
+	var settings struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal(indexSettings(nil), &settings); err != nil {
+		t.Fatal(err)
+	}
+
+	return newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			peopleIndex: map[string]interface{}{"mappings": settings.Mappings},
+		})
+	})
+}
+
+// TestCheckIndexBootstrapMapping checks the mapping created by bootstrap
+// against the fields checkIndex expects.
+func TestCheckIndexBootstrapMapping(t *testing.T) {
+	if errs := checkIndex(context.Background(), mappingClient(t)); len(errs) != 0 {
+		t.Errorf("checkIndex() = %q, want no errors", errs)
+	}
+}
+
+func TestCheckIndexLanguages(t *testing.T) {
+	defer func(saved map[string]string) { languages = saved }(languages)
+
+	languages = nil
+	es := mappingClient(t)
+	languages = map[string]string{"de": "german"}
+	if errs := checkIndex(context.Background(), es); len(errs) != len(languageFields) {
+		t.Errorf("checkIndex() of an index without language sub-fields = %q, want one error per language field", errs)
+	}
+
+	if errs := checkIndex(context.Background(), mappingClient(t)); len(errs) != 0 {
+		t.Errorf("checkIndex() of an index with language sub-fields = %q, want no errors", errs)
+	}
+}
+
+func TestCheckIndexMissingField(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"people":{"mappings":{"properties":{"title":{"type":"text"}}}}}`))
+	})
+
+	errs := checkIndex(context.Background(), es)
+	if want := len(searchFields) - 1 + len(lowercaseFields); len(errs) != want {
+		t.Errorf("checkIndex() = %q, want %d missing fields", errs, want)
+	}
+}
+
+// TestHealthCheckerTimeout checks a cluster that does not answer is reported
+// unavailable within the check interval.
+func TestHealthCheckerTimeout(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	c := &healthChecker{es: es, interval: 100 * time.Millisecond}
+
+	start := time.Now()
+	result := c.check(context.Background())
+	if len(result.cluster) == 0 {
+		t.Error("check of an unresponsive cluster reported no errors")
+	}
+	if elapsed := time.Since(start); elapsed >= c.interval {
+		t.Errorf("check took %v, want less than the %v interval", elapsed, c.interval)
+	}
+}
+
+// TestHealthzProbeContext checks a probe without a background checker stops
+// checking when its request ends.
+func TestHealthzProbeContext(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	healthzHandler(discardLogger, es, nil)(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil).WithContext(ctx))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+}
+
+// TestCheckIndexAlias checks the mappings of the indices behind a people
+// alias are the ones checked.
+func TestCheckIndexAlias(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"people-000001":{"mappings":{"properties":{"title":{"type":"text"}}}}}`))
+	})
+
+	errs := checkIndex(context.Background(), es)
+	if want := len(searchFields) - 1 + len(lowercaseFields); len(errs) != want {
+		t.Fatalf("checkIndex() = %q, want %d missing fields", errs, want)
+	}
+	if want := `field "last_name" missing from "people-000001" mapping`; errs[0] != want {
+		t.Errorf("checkIndex() = %q, want it to name the concrete index", errs[0])
+	}
+}
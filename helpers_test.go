@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+var discardLogger = log.New(io.Discard, "", 0)
+
+func TestMain(m *testing.M) {
+	// Flag defaults are only applied by main.
+	peopleIndex = "people"
+	os.Exit(m.Run())
+}
+
+// newTestClient returns a client of a fake cluster served by handler.
+func newTestClient(t *testing.T, handler http.HandlerFunc) *elasticsearch.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	es, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses:    []string{srv.URL},
+		DisableRetry: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return es
+}
+
+// deadlineHandler reports whether the write deadline of the response can be
+// lifted through the writer it is given, as streaming handlers do.
+func deadlineHandler(w http.ResponseWriter, r *http.Request) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// assertDeadline checks deadlineHandler wrapped in h can lift the write
+// deadline.
+func assertDeadline(t *testing.T, h http.Handler) {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(res.Body)
+		t.Errorf("SetWriteDeadline through the middleware: %s", body)
+	}
+}
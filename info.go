@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// infoRefreshTimeout bounds a background refresh of the cached cluster info.
+const infoRefreshTimeout = 5 * time.Second
+
+// infoCache keeps the cluster info served by GET / for ttl. Once stale it
+// is still served while a single background refresh replaces it, so only the
+// first request ever waits for the cluster. A ttl of 0 disables caching.
+type infoCache struct {
+	es  *elasticsearch.Client
+	ttl time.Duration
+
+	mu         sync.Mutex
+	body       []byte
+	fetched    time.Time
+	refreshing bool
+}
+
+// get returns the compact JSON of the cluster info.
+func (c *infoCache) get(ctx context.Context, logger *log.Logger) ([]byte, error) {
+	if c.ttl <= 0 {
+		return c.fetch(ctx)
+	}
+
+	c.mu.Lock()
+	body := c.body
+	if body != nil && time.Since(c.fetched) > c.ttl && !c.refreshing {
+		c.refreshing = true
+		go c.refresh(logger)
+	}
+	c.mu.Unlock()
+
+	if body != nil {
+		return body, nil
+	}
+
+	body, err := c.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.store(body)
+
+	return body, nil
+}
+
+func (c *infoCache) refresh(logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), infoRefreshTimeout)
+	defer cancel()
+
+	body, err := c.fetch(ctx)
+	if err != nil {
+		logger.Println("Could not refresh cluster info, serving the cached one:", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	if err == nil {
+		c.body, c.fetched = body, time.Now()
+	}
+}
+
+func (c *infoCache) store(body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.body, c.fetched = body, time.Now()
+}
+
+func (c *infoCache) fetch(ctx context.Context) ([]byte, error) {
+	res, err := withRetry(ctx, esRetries, esRetryBackoff, func() (*esapi.Response, error) {
+		return c.es.Info(c.es.Info.WithContext(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := json.Compact(&body, raw); err != nil {
+		return nil, err
+	}
+
+	return body.Bytes(), nil
+}
+
+// infoHandler serves the cluster info from cache, pretty printed with
+// pretty=true.
+func infoHandler(logger *log.Logger, cache *infoCache) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		body, err := cache.get(r.Context(), logger)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := jsonOutput(w, r).Write(append(body, '\n')); err != nil {
+			logger.Println("info aborted:", err)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// searchSlotWait is how long a search waits for a free slot before it is
+// turned away.
+const searchSlotWait = time.Second
+
+// searchLimiter caps the number of searches sent to the cluster at once. A
+// nil searchLimiter doesn't limit anything.
+type searchLimiter chan struct{}
+
+// newSearchLimiter returns a limiter allowing max concurrent searches, or nil
+// when max is 0.
+func newSearchLimiter(max int) searchLimiter {
+	if max <= 0 {
+		return nil
+	}
+
+	return make(searchLimiter, max)
+}
+
+// acquire waits for a free slot for up to searchSlotWait, or until ctx is
+// done. It returns false when no slot was obtained, and otherwise the
+// function releasing the slot.
+func (l searchLimiter) acquire(ctx context.Context) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	timer := time.NewTimer(searchSlotWait)
+	defer timer.Stop()
+
+	select {
+	case l <- struct{}{}:
+		return func() { <-l }, true
+	case <-ctx.Done():
+		return nil, false
+	case <-timer.C:
+		return nil, false
+	}
+}
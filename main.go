@@ -1,14 +1,14 @@
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -16,62 +16,379 @@ import (
 
 	"github.com/elastic/go-elasticsearch/v7"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
 )
 
-const searchMatch = `
-	"query": {
-		"multi_match": {
-		"query": %q,
-		"fields": ["lastName^100", "firstName^10", "country", "title"],
-		"operator": "and"
-		}
-	},
-	"highlight": {
-		"fields": {
-		"lastName": { "number_of_fragments": 0 },
-		"firstName": { "number_of_fragments": 0 },
-		"country": { "number_of_fragments": 0 },
-		"title": { "number_of_fragments": 0 }
-		}
-	},
-	"size": 25,
-	"sort": [{ "_score": "desc" }, { "_doc": "asc" }]`
+// appVersion is the version of the server, set at build time with
+// -ldflags "-X main.appVersion=<version>".
+var appVersion = "dev"
 
 var (
-	listenAddr  string
-	esAddresses string
+	listenAddr            string
+	esAddresses           string
+	peopleIndex           string
+	enableAdmin           bool
+	synonymsFile          string
+	runBootstrap          bool
+	autoBootstrap         bool
+	bootstrapMode         string
+	bootstrapBatch        int
+	bootstrapWorkers      int
+	settingsFile          string
+	templatePattern       string
+	templateFile          string
+	ilmPolicyFile         string
+	esMetrics             bool
+	regionsFile           string
+	dryRun                bool
+	healthInterval        time.Duration
+	healthzInterval       time.Duration
+	esCompress            bool
+	esVersion             int
+	esUsername            string
+	esPassword            string
+	esCACert              string
+	esUserAgent           string
+	esSelector            string
+	selftest              bool
+	checkMapping          bool
+	warmupSearches        bool
+	cityBoost             float64
+	fieldOperators        string
+	languagesFlag         string
+	phoneticNames         bool
+	phoneticEncoder       string
+	lowercaseQuery        bool
+	defaultFilterJSON     string
+	debugBodies           bool
+	debugBodiesMax        int
+	logESNode             bool
+	debugESNode           bool
+	responseEnvelope      bool
+	maxHeaderBytes        int
+	keepAlives            bool
+	idleTimeout           time.Duration
+	writeTimeout          time.Duration
+	esQueryTimeout        time.Duration
+	apiKey                string
+	shutdownTimeout       time.Duration
+	drainDelay            time.Duration
+	slowlogSize           int
+	slowlogThreshold      time.Duration
+	slowThreshold         time.Duration
+	maxConcurrentSearches int
+	accessLogPath         string
+
+	esFallbackAddresses   string
+	fallbackThreshold     int
+	fallbackProbeInterval time.Duration
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	esRetries      int
+	esRetryBackoff time.Duration
+	infoCacheTTL   time.Duration
 )
 
 // Person person struct
 type Person struct {
-	ID        string `json:"id"`
-	Title     string `json:"title"`
-	FirstName string `json:"first_name"`
-	LastName  string `json:"last_name"`
-	Email     string `json:"email"`
-	Country   string `json:"country"`
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	FirstName string   `json:"first_name"`
+	LastName  string   `json:"last_name"`
+	Email     string   `json:"email"`
+	Country   string   `json:"country"`
+	Address   *Address `json:"address,omitempty"`
+}
+
+// Address address struct
+type Address struct {
+	Street   string `json:"street,omitempty"`
+	City     string `json:"city,omitempty"`
+	Postcode string `json:"postcode,omitempty"`
 }
 
 func main() {
 	flag.StringVar(&listenAddr, "listen-addr", ":5000", "server listen address")
 	flag.StringVar(&esAddresses, "es-addresses", "http://es01:9200,http://es02:9200",
 		"elastic addresses")
+	flag.IntVar(&esVersion, "es-version", 7,
+		"major version of the elastic cluster, 7 or 8")
+	flag.StringVar(&esUsername, "es-username", "", "elastic basic auth username")
+	flag.StringVar(&esPassword, "es-password", "", "elastic basic auth password")
+	flag.StringVar(&esCACert, "es-ca-cert", "",
+		"PEM file with the CA certificate of the elastic cluster")
+	flag.StringVar(&esSelector, "es-selector", selectorRoundRobin,
+		"how requests are spread over the elastic nodes: round-robin, random or first")
+	flag.StringVar(&esUserAgent, "es-user-agent", "es-demo/"+appVersion,
+		"User-Agent sent with elastic requests")
+	flag.IntVar(&breakerThreshold, "breaker-threshold", 5,
+		"consecutive elastic failures that open the circuit breaker, 0 disables it")
+	flag.DurationVar(&breakerCooldown, "breaker-cooldown", 30*time.Second,
+		"how long the circuit breaker stays open before probing the cluster again")
+	flag.IntVar(&esRetries, "es-retries", 2,
+		"times the info call and searches are retried after a transport error or a 502, 503 or 504")
+	flag.DurationVar(&esRetryBackoff, "es-retry-backoff", 200*time.Millisecond,
+		"wait before the first retry, doubled after each")
+	flag.DurationVar(&infoCacheTTL, "info-cache-ttl", 30*time.Second,
+		"how long the cluster info served at / is cached, 0 fetches it on every request")
+	flag.StringVar(&esFallbackAddresses, "es-addresses-fallback", "",
+		"elastic addresses of a fallback cluster serving reads when the primary fails")
+	flag.IntVar(&fallbackThreshold, "fallback-threshold", 5,
+		"consecutive primary read failures before switching to the fallback")
+	flag.DurationVar(&fallbackProbeInterval, "fallback-probe-interval", 10*time.Second,
+		"how often the primary is probed while reads use the fallback")
+	flag.IntVar(&maxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes,
+		"maximum size of request headers")
+	flag.BoolVar(&keepAlives, "keep-alives", true, "enable HTTP keep-alives")
+	flag.DurationVar(&idleTimeout, "idle-timeout", 15*time.Second,
+		"how long idle keep-alive connections are kept open")
+	flag.DurationVar(&writeTimeout, "write-timeout", 10*time.Second,
+		"how long the server may take to write a response, 0 for no limit")
+	flag.DurationVar(&esQueryTimeout, "es-query-timeout", 8*time.Second,
+		"how long a search may wait for elastic, at most 80% of -write-timeout")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second,
+		"how long to wait for in-flight requests to finish on shutdown")
+	flag.DurationVar(&drainDelay, "drain-delay", 0,
+		"how long /healthz reports unavailable on shutdown before new connections are refused")
+	flag.StringVar(&apiKey, "api-key", "",
+		"require this bearer token on mutating requests")
+	flag.IntVar(&slowlogSize, "slowlog-size", 100,
+		"number of slow searches kept for the admin /slowlog endpoint")
+	flag.DurationVar(&slowlogThreshold, "slowlog-threshold", 100*time.Millisecond,
+		"minimum duration of a search recorded in the slowlog")
+	flag.DurationVar(&slowThreshold, "slow-threshold", time.Second,
+		"log a warning for requests taking longer than this, 0 disables it")
+	flag.IntVar(&maxConcurrentSearches, "max-concurrent-searches", 0,
+		"maximum number of searches sent to elastic at once, 0 for no limit")
+	flag.StringVar(&accessLogPath, "access-log", "",
+		"write a JSON-lines access log to this file, or to stdout for -")
+	flag.StringVar(&peopleIndex, "index", "people", "elastic index holding people")
+	flag.BoolVar(&enableAdmin, "enable-admin", false,
+		"register administrative endpoints")
+	flag.BoolVar(&esMetrics, "es-metrics", false,
+		"collect elastic client metrics and expose them at /es-metrics")
+	flag.BoolVar(&esCompress, "es-compress-requests", false,
+		"gzip request bodies sent to elastic")
+	flag.Float64Var(&cityBoost, "city-boost", 1, "boost of the address city in searches")
+	flag.StringVar(&fieldOperators, "field-operators", "",
+		"per-field match operators as field:operator pairs, e.g. country:or,title:or")
+	flag.StringVar(&languagesFlag, "languages", "",
+		"language sub-fields of names and titles as code:analyzer pairs, e.g. en:english,de:german")
+	flag.BoolVar(&phoneticNames, "phonetic", false,
+		"index names with a phonetic sub-field for phonetic=true searches, requires the analysis-phonetic plugin")
+	flag.StringVar(&phoneticEncoder, "phonetic-encoder", "double_metaphone",
+		"encoder of the phonetic sub-fields, e.g. double_metaphone, beider_morse or cologne")
+	flag.BoolVar(&debugBodies, "debug-bodies", false,
+		"log elastic request and response bodies, which may contain sensitive data")
+	flag.IntVar(&debugBodiesMax, "debug-bodies-max", 2048,
+		"maximum number of body bytes logged by -debug-bodies")
+	flag.BoolVar(&logESNode, "log-es-node", false,
+		"log the elastic node that served each request")
+	flag.BoolVar(&debugESNode, "debug-es-node", false,
+		"report the elastic nodes that served a request in the X-ES-Node header")
+	flag.BoolVar(&responseEnvelope, "response-envelope", false,
+		"wrap successful JSON responses in a {\"data\", \"meta\"} envelope")
+	flag.BoolVar(&lowercaseQuery, "lowercase-query", false, "lowercase search queries")
+	flag.StringVar(&defaultFilterJSON, "default-filter", "",
+		"JSON query clause every search is filtered by unless include_all=true is passed")
+	flag.BoolVar(&runBootstrap, "bootstrap", true,
+		"recreate and seed the people index on startup")
+	flag.BoolVar(&autoBootstrap, "auto-bootstrap", false,
+		"create and seed the people index when a search finds it missing, then retry the search")
+	flag.StringVar(&bootstrapMode, "bootstrap-mode", bootstrapRecreate,
+		"recreate: delete, create and seed the index; ensure: create and seed it only if missing")
+	flag.IntVar(&bootstrapBatch, "bootstrap-batch-size", 500,
+		"number of documents per bulk request when seeding the index")
+	flag.IntVar(&bootstrapWorkers, "bootstrap-workers", 4,
+		"number of bulk requests sent concurrently when seeding the index")
+	flag.StringVar(&settingsFile, "index-settings-file", "",
+		"JSON file of dynamic index settings applied to an existing index in ensure mode")
+	flag.StringVar(&templatePattern, "index-template-pattern", "",
+		"install an index template for indices matching this pattern, e.g. people-*")
+	flag.StringVar(&templateFile, "index-template-file", "",
+		"JSON file with the template settings and mappings, defaults to those of the people index")
+	flag.StringVar(&ilmPolicyFile, "ilm-policy-file", "",
+		"JSON file with an ILM policy installed on bootstrap and attached to the index template")
+	flag.StringVar(&synonymsFile, "synonyms-file", "",
+		"file with synonym rules applied to the country field")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"log the operations bootstrap would perform and exit")
+	flag.DurationVar(&healthInterval, "health-interval", 5*time.Second,
+		"how often /events/health polls cluster health")
+	flag.DurationVar(&healthzInterval, "healthz-interval", 5*time.Second,
+		"how often the health reported by /healthz is checked in the background, 0 checks on every probe")
+	flag.BoolVar(&checkMapping, "check-mapping", false,
+		"fail startup unless every searched field exists in the people index mapping")
+	flag.BoolVar(&selftest, "selftest", false,
+		"index, search and delete a probe document, then exit 0 on success or 1 on failure")
+	flag.BoolVar(&warmupSearches, "warmup", false,
+		"run a few representative searches on startup to prime the caches")
+	flag.StringVar(&regionsFile, "regions-file", "",
+		"JSON file mapping country names to regions")
 	flag.Parse()
 
+	setFieldBoost("address.city", cityBoost)
+
 	logger := log.New(os.Stdout, "http: ", log.LstdFlags)
+	logBanner(logger)
+
+	if err := setFieldOperators(fieldOperators); err != nil {
+		logger.Fatalf("Invalid -field-operators: %v", err)
+	}
+
+	langs, err := parseLanguages(languagesFlag)
+	if err != nil {
+		logger.Fatalf("Invalid -languages: %v", err)
+	}
+	languages = langs
+
+	if esVersion != 7 && esVersion != 8 {
+		logger.Fatalf("Invalid -es-version %d, expected 7 or 8", esVersion)
+	}
+
+	if esSelector != selectorRoundRobin && esSelector != selectorRandom && esSelector != selectorFirst {
+		logger.Fatalf("Invalid -es-selector %q, expected %s, %s or %s",
+			esSelector, selectorRoundRobin, selectorRandom, selectorFirst)
+	}
+
+	if writeTimeout > 0 && (esQueryTimeout <= 0 || esQueryTimeout >= writeTimeout) {
+		adjusted := writeTimeout * 8 / 10
+		logger.Printf("WARN -es-query-timeout %s is not shorter than -write-timeout %s, using %s",
+			esQueryTimeout, writeTimeout, adjusted)
+		esQueryTimeout = adjusted
+	}
+
+	if slowlogSize < 0 {
+		logger.Fatalf("Invalid -slowlog-size %d, expected 0 or more", slowlogSize)
+	}
+
+	if bootstrapMode != bootstrapRecreate && bootstrapMode != bootstrapEnsure {
+		logger.Fatalf("Invalid -bootstrap-mode %q, expected %s or %s",
+			bootstrapMode, bootstrapRecreate, bootstrapEnsure)
+	}
+
+	if ilmPolicyFile != "" && templatePattern == "" {
+		logger.Fatalf("-ilm-policy-file requires -index-template-pattern")
+	}
 
 	done := make(chan bool, 1)
 	quit := make(chan os.Signal, 1)
 
 	signal.Notify(quit, os.Interrupt)
 
-	es := newEsClient(logger, strings.Split(esAddresses, ","))
-	err := bootstrap(es)
+	filter, err := loadDefaultFilter(defaultFilterJSON)
+	if err != nil {
+		logger.Fatalf("Invalid -default-filter: %v", err)
+	}
+	defaultFilter = filter
+
+	regions, err := loadRegions(regionsFile)
 	if err != nil {
 		panic(err)
 	}
 
-	server := newWebServer(logger, es)
+	addresses, err := parseAddresses(esAddresses)
+	if err != nil {
+		logger.Fatalf("Invalid -es-addresses: %v", err)
+	}
+
+	es := newEsClient(logger, addresses)
+	var opts bootstrapOptions
+	if runBootstrap || dryRun || autoBootstrap {
+		synonyms, err := loadSynonyms(synonymsFile)
+		if err != nil {
+			panic(err)
+		}
+
+		settings, err := loadIndexSettings(settingsFile)
+		if err != nil {
+			panic(err)
+		}
+
+		var template []byte
+		if templateFile != "" {
+			if template, err = os.ReadFile(templateFile); err != nil {
+				panic(err)
+			}
+		}
+
+		var policy []byte
+		if ilmPolicyFile != "" {
+			if policy, err = os.ReadFile(ilmPolicyFile); err != nil {
+				panic(err)
+			}
+		}
+
+		opts = bootstrapOptions{
+			Mode:            bootstrapMode,
+			Synonyms:        synonyms,
+			Settings:        settings,
+			TemplatePattern: templatePattern,
+			TemplateBody:    template,
+			LifecyclePolicy: policy,
+			DryRun:          dryRun,
+			BatchSize:       bootstrapBatch,
+			Workers:         bootstrapWorkers,
+		}
+	}
+
+	if runBootstrap || dryRun {
+		if err := bootstrap(es, logger, opts); err != nil {
+			panic(err)
+		}
+	} else {
+		logger.Println("Skipping bootstrap")
+	}
+
+	if dryRun {
+		logger.Println("Dry run complete, exiting")
+		return
+	}
+
+	if checkMapping {
+		if errs := checkIndex(context.Background(), es); len(errs) > 0 {
+			logger.Fatalf("Mapping check failed: %s", strings.Join(errs, "; "))
+		}
+		logger.Println("Mapping check passed")
+	}
+
+	if selftest {
+		if !selfTest(es, logger) {
+			logger.Println("Selftest failed")
+			os.Exit(1)
+		}
+		logger.Println("Selftest passed")
+		return
+	}
+
+	if warmupSearches {
+		warmup(es, logger)
+	}
+
+	var fallback *elasticsearch.Client
+	if esFallbackAddresses != "" {
+		fallbackAddresses, err := parseAddresses(esFallbackAddresses)
+		if err != nil {
+			logger.Fatalf("Invalid -es-addresses-fallback: %v", err)
+		}
+		fallback = newEsClient(logger, fallbackAddresses)
+	}
+	reads := newFailover(logger, es, fallback, fallbackThreshold, fallbackProbeInterval)
+
+	var rebootstrap func() error
+	if autoBootstrap {
+		rebootstrap = onDemandBootstrap(es, logger, opts)
+	}
+
+	access, err := openAccessLog(accessLogPath)
+	if err != nil {
+		logger.Fatalf("Could not open -access-log: %v", err)
+	}
+
+	server := newWebServer(logger, es, reads, regions, rebootstrap, access)
 	go gracefulShutdown(server, logger, quit, done)
 
 	logger.Println("Server is ready to handle requests at", listenAddr)
@@ -89,160 +406,274 @@ func gracefulShutdown(server *http.Server, logger *log.Logger, quit <-chan os.Si
 	<-quit
 	logger.Println("Server is shutting down...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	draining.Store(true)
+	if drainDelay > 0 {
+		logger.Printf("Draining: reporting unhealthy for %s before closing listeners", drainDelay)
+		time.Sleep(drainDelay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	start := time.Now()
 	server.SetKeepAlivesEnabled(false)
 	if err := server.Shutdown(ctx); err != nil {
-		logger.Fatalf("Could not gracefully shutdown the server: %v", err)
+		logger.Printf("Could not drain requests within %s, forcing shutdown: %v", shutdownTimeout, err)
+		server.Close()
+	} else {
+		logger.Printf("Drained in-flight requests in %s", time.Since(start))
 	}
 
 	close(done)
 }
 
-func newWebServer(logger *log.Logger, es *elasticsearch.Client) *http.Server {
+// newWebServer builds the API server. rebootstrap, when not nil, recreates a
+// missing people index before a failed search is retried. access, when not
+// nil, receives the access log.
+func newWebServer(logger *log.Logger, es *elasticsearch.Client, reads *failover,
+	regions map[string]string, rebootstrap func() error, access io.Writer) *http.Server {
+
 	router := http.NewServeMux()
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	slow := newSlowLog(slowlogSize, slowlogThreshold)
+	limiter := newSearchLimiter(maxConcurrentSearches)
+
+	router.HandleFunc("/", infoHandler(logger, &infoCache{es: es, ttl: infoCacheTTL}))
+
+	var checker *healthChecker
+	if healthzInterval > 0 {
+		checker = &healthChecker{es: es, interval: healthzInterval}
+	}
+	router.HandleFunc("/healthz", healthzHandler(logger, es, checker))
+	router.HandleFunc("/people", createPersonHandler(logger, es))
+	router.HandleFunc("/people/", peopleHandler(logger, es, reads))
+	router.HandleFunc("/people:mget", mgetPeopleHandler(logger, reads))
+	router.HandleFunc("/es-metrics", esMetricsHandler(logger, reads))
+	router.HandleFunc("/regions", regionsHandler(logger, es, regions))
+	router.HandleFunc("/countries", countriesHandler(logger, es))
+	router.HandleFunc("/search/template", searchTemplateHandler(logger, es))
+	ex := newExports()
+	router.HandleFunc("/export", exportHandler(logger, es, ex))
+	router.HandleFunc("/export/", exportHandler(logger, es, ex))
+	router.HandleFunc("/export.csv", exportCSVHandler(logger, es, ex))
+	router.HandleFunc("/events/health", healthEventsHandler(logger, es))
+	router.Handle("/ui/", uiHandler(logger))
+	router.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
+	if enableAdmin {
+		router.HandleFunc("/refresh", refreshHandler(logger, es))
+		router.HandleFunc("/flush", flushHandler(logger, es))
+		router.HandleFunc("/cache/clear", clearCacheHandler(logger, es))
+		router.HandleFunc("/analyze", analyzeHandler(logger, es))
+		router.HandleFunc("/slowlog", slowLogHandler(logger, slow))
+		router.HandleFunc("/diagnostics", diagnosticsHandler(logger, es))
+		router.HandleFunc("/people:update-by-query", updateByQueryHandler(logger, es))
+		router.HandleFunc("/search/raw", rawSearchHandler(logger, es))
+		router.HandleFunc("/search-templates/", putSearchTemplateHandler(logger, es))
+		router.HandleFunc("/rollover", rolloverHandler(logger, es))
+	}
+
+	router.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
 		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
 
-		read, write := io.Pipe()
+		start := time.Now()
+		defer func() { slow.record(r, time.Since(start)) }()
 
-		go func() {
-			defer write.Close()
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
 
-			esInfo, err := es.Info()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			} else {
-				defer esInfo.Body.Close()
-				io.Copy(write, esInfo.Body)
-			}
-		}()
+		sq, err := parseSearchQuery(w, r)
+		if err != nil {
+			writeError(w, bodyErrorStatus(err), err.Error())
+			return
+		}
 
-		io.Copy(w, read)
-		w.WriteHeader(http.StatusOK)
-	})
+		release, ok := limiter.acquire(r.Context())
+		if !ok {
+			w.Header().Set("Retry-After", "1")
+			writeError(w, http.StatusServiceUnavailable, "too many concurrent searches")
+			return
+		}
+		defer release()
 
-	router.HandleFunc("/search", func(w http.ResponseWriter, r *http.Request) {
-		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+		ctx := r.Context()
+		if esQueryTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, esQueryTimeout)
+			defer cancel()
+		}
 
-		q := r.URL.Query().Get("q")
+		client := reads.client()
+		opts := []func(*esapi.SearchRequest){
+			client.Search.WithContext(ctx),
+			client.Search.WithIndex(peopleIndex),
+			client.Search.WithTrackTotalHits(sq.TrackTotalHits),
+		}
 
-		read, write := io.Pipe()
+		if sq.SearchType != "" {
+			opts = append(opts, client.Search.WithSearchType(sq.SearchType))
+		}
+		if sq.Preference != "" {
+			opts = append(opts, client.Search.WithPreference(sq.Preference))
+		}
+		if sq.Explain {
+			opts = append(opts, client.Search.WithExplain(true))
+		}
+		if sq.TerminateAfter > 0 {
+			opts = append(opts, client.Search.WithTerminateAfter(sq.TerminateAfter))
+		}
+		search := func() (*esapi.Response, error) {
+			return withRetry(ctx, esRetries, esRetryBackoff, func() (*esapi.Response, error) {
+				return client.Search(append(opts[:len(opts):len(opts)], client.Search.WithBody(buildQuery(sq)))...)
+			})
+		}
+
+		res, err := search()
+		var esErr error
+		if err == nil {
+			esErr = eserr.FromResponse(res)
+		}
+		if rebootstrap != nil && eserr.IsIndexNotFound(esErr) {
+			res.Body.Close()
+			logger.Printf("Index %q not found, bootstrapping it before retrying the search", peopleIndex)
+			if err = rebootstrap(); err == nil {
+				if res, err = search(); err == nil {
+					esErr = eserr.FromResponse(res)
+				}
+			}
+		}
+		reads.report(client, res, err)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
 
-		go func() {
-			defer write.Close()
+		if esErr != nil {
+			writeESError(w, esErr)
+			return
+		}
 
-			res, err := es.Search(
-				es.Search.WithContext(r.Context()),
-				es.Search.WithIndex("people"),
-				es.Search.WithBody(buildQuery(q)),
-				es.Search.WithTrackTotalHits(true),
-			)
+		body := contextReader{r.Context(), res.Body}
+		if sq.IDsOnly {
+			ids, err := transformIDs(body)
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-			} else {
-				defer res.Body.Close()
-				io.Copy(write, res.Body)
+				writeError(w, http.StatusBadGateway, err.Error())
+				return
+			}
+			if ids.Total != nil {
+				setTotalHits(w, *ids.Total, ids.TotalRelation)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			if err := encodeIDsJSON(jsonOutput(w, r), ids); err != nil {
+				logger.Println("search aborted:", err)
 			}
-		}()
+			return
+		}
+		if streamsResults(r) && !acceptsCSV(r) {
+			w.Header().Set("Content-Type", ndjson.ContentType)
+			onTotal := func(total int, relation string) { setTotalHits(w, total, relation) }
+			if err := streamSearch(body, jsonOutput(flushWriter{w}, r), onTotal); err != nil {
+				logger.Println("search aborted:", err)
+			}
+			return
+		}
+
+		// Decode the whole response before writing, so a malformed one is
+		// still reported with an error status.
+		out, err := transformSearch(body)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
 
-		io.Copy(w, read)
+		if out.Total != nil {
+			setTotalHits(w, *out.Total, out.TotalRelation)
+		}
+		if acceptsCSV(r) {
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			err = encodeSearchCSV(w, out)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			err = encodeSearchJSON(jsonOutput(w, r), out)
+		}
+		if err != nil {
+			logger.Println("search aborted:", err)
+		}
 	})
 
-	return &http.Server{
-		Addr:         listenAddr,
-		Handler:      router,
-		ErrorLog:     logger,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
+	var handler http.Handler = withAPIKey(apiKey, router)
+	handler = withoutTrailingSlash(router, handler)
+	handler = withESNode(debugESNode, handler)
+	handler = withEnvelope(responseEnvelope, handler)
+	handler = withRecovery(logger, handler)
+	handler = withSlowWarning(logger, slowThreshold, handler)
+	handler = withAccessLog(access, handler)
+	handler = withTraceHeaders(handler)
+	handler = withRequestID(handler)
+
+	server := &http.Server{
+		Addr:           listenAddr,
+		Handler:        handler,
+		ErrorLog:       logger,
+		ReadTimeout:    5 * time.Second,
+		WriteTimeout:   writeTimeout,
+		IdleTimeout:    idleTimeout,
+		MaxHeaderBytes: maxHeaderBytes,
 	}
-}
+	server.SetKeepAlivesEnabled(keepAlives)
 
-func newEsClient(logger *log.Logger, addresses []string) *elasticsearch.Client {
-	cfg := elasticsearch.Config{Addresses: addresses}
-	client, err := elasticsearch.NewClient(cfg)
-	if err != nil {
-		logger.Println(err)
-		panic(err)
+	if checker != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		server.RegisterOnShutdown(cancel)
+		go checker.run(ctx)
 	}
 
-	return client
+	return server
 }
 
-func buildQuery(query string) io.Reader {
-	var b strings.Builder
-
-	b.WriteString("{\n")
-	b.WriteString(fmt.Sprintf(searchMatch, query))
-	b.WriteString("\n}")
-
-	return strings.NewReader(b.String())
+// contextReader stops reading as soon as its context is done, so copies
+// abort promptly when the client disconnects.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func bootstrap(es *elasticsearch.Client) error {
-	idx := "people"
-	ctx := context.Background()
-	_, err := esapi.IndicesDeleteRequest{Index: []string{idx}}.Do(ctx, es)
-	if err != nil {
-		return err
-	}
-
-	_, err2 := esapi.IndicesCreateRequest{Index: idx}.Do(ctx, es)
-	if err2 != nil {
-		return err2
+func (c contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
 	}
 
-	people := make([]*Person, 4)
-	people = append(people, &Person{
-		ID:        "1",
-		Title:     "Mr.",
-		FirstName: "Marco",
-		LastName:  "Franssen",
-		Email:     "marco.franssen@elasticsearch.com",
-		Country:   "The Netherlands",
-	})
-	people = append(people, &Person{
-		ID:        "2",
-		Title:     "Mr.",
-		FirstName: "John",
-		LastName:  "Doe",
-		Email:     "john.doe@elasticsearch.com",
-		Country:   "Neverland",
-	})
-	people = append(people, &Person{
-		ID:        "3",
-		Title:     "Mrs.",
-		FirstName: "Jane",
-		LastName:  "Doe",
-		Email:     "jane.doe@golang.org",
-		Country:   "Neverland",
-	})
-	people = append(people, &Person{
-		ID:        "4",
-		Title:     "Mr.",
-		FirstName: "Rob",
-		LastName:  "Pike",
-		Email:     "rob.pike@golang.org",
-		Country:   "Unknown",
-	})
+	return c.r.Read(p)
+}
 
-	for _, p := range people {
-		payload, err := json.Marshal(p)
-		if err != nil {
-			return err
+// parseAddresses splits a comma separated list of node URLs, rejecting an
+// empty list and any entry that isn't an absolute http or https URL.
+func parseAddresses(s string) ([]string, error) {
+	var addresses, bad []string
+	for _, a := range strings.Split(s, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
 		}
 
-		_, err3 := esapi.CreateRequest{
-			Index:      idx,
-			DocumentID: p.ID,
-			Body:       bytes.NewReader(payload),
-		}.Do(ctx, es)
-		if err3 != nil {
-			return err3
+		u, err := url.Parse(a)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			bad = append(bad, a)
+			continue
 		}
+		addresses = append(addresses, a)
+	}
+
+	if len(bad) > 0 {
+		return nil, fmt.Errorf("malformed addresses, expected http(s)://host:port: %s",
+			strings.Join(bad, ", "))
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("no addresses given")
 	}
 
-	return nil
+	return addresses, nil
 }
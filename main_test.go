@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSearchClientCancellation checks a search whose client goes away is
+// aborted on the cluster and not reported as a server error.
+func TestSearchClientCancellation(t *testing.T) {
+	started := make(chan struct{})
+	aborted := make(chan struct{})
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/_search") {
+			http.NotFound(w, r)
+			return
+		}
+		// The server only notices the client closing the connection once the
+		// request body is consumed.
+		io.Copy(io.Discard, r.Body)
+		close(started)
+		<-r.Context().Done()
+		close(aborted)
+	})
+
+	var logs, access bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	server := newWebServer(logger, es, newFailover(logger, es, nil, 0, 0), nil, nil, &access)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	r := httptest.NewRequest(http.MethodGet, "/search?q=rob", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, r)
+
+	select {
+	case <-aborted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("search still running on the cluster after the client went away")
+	}
+
+	if rec.Code != statusClientClosedRequest {
+		t.Errorf("status = %d, want %d", rec.Code, statusClientClosedRequest)
+	}
+	var entry accessEntry
+	if err := json.Unmarshal(access.Bytes(), &entry); err != nil {
+		t.Fatalf("access log %q: %v", access.String(), err)
+	}
+	if entry.Status >= http.StatusInternalServerError {
+		t.Errorf("access log status = %d, want no server error", entry.Status)
+	}
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr string
+	}{
+		{in: "http://es01:9200", want: []string{"http://es01:9200"}},
+		{in: " http://es01:9200 , https://es02:9200,", want: []string{"http://es01:9200", "https://es02:9200"}},
+		{in: "", wantErr: "no addresses given"},
+		{in: " , ,", wantErr: "no addresses given"},
+		{in: "es01:9200", wantErr: "malformed addresses, expected http(s)://host:port: es01:9200"},
+		{in: "http://es01:9200,ftp://es02,http://", wantErr: "malformed addresses, expected http(s)://host:port: ftp://es02, http://"},
+		{in: "http://es01:9200/%zz", wantErr: "malformed addresses, expected http(s)://host:port: http://es01:9200/%zz"},
+	}
+	for _, tt := range tests {
+		got, err := parseAddresses(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("parseAddresses(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("parseAddresses(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// TestPeopleRoutesKeepIDs checks ids named after the bulk endpoints are
+// served as people, not shadowed by those endpoints.
+func TestPeopleRoutesKeepIDs(t *testing.T) {
+	defer func(saved bool) { enableAdmin = saved }(enableAdmin)
+	enableAdmin = true
+
+	var gets []string
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/people/_doc/") {
+			gets = append(gets, strings.TrimPrefix(r.URL.Path, "/people/_doc/"))
+			w.Write([]byte(`{"found":true,"_seq_no":1,"_primary_term":1,"_source":{"id":"x"}}`))
+			return
+		}
+		w.Write([]byte(`{"docs":[]}`))
+	})
+	server := newWebServer(discardLogger, es, newFailover(discardLogger, es, nil, 0, 0), nil, nil, io.Discard)
+
+	for _, id := range []string{"mget", "update-by-query"} {
+		rec := httptest.NewRecorder()
+		server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/people/"+id, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("GET /people/%s status = %d: %s", id, rec.Code, rec.Body)
+		}
+	}
+	if strings.Join(gets, ",") != "mget,update-by-query" {
+		t.Errorf("person lookups = %v, want mget and update-by-query", gets)
+	}
+
+	rec := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/people:mget", strings.NewReader(`{"ids":["1"]}`)))
+	if rec.Code != http.StatusOK {
+		t.Errorf("POST /people:mget status = %d: %s", rec.Code, rec.Body)
+	}
+}
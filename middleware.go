@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	esNodesKey
+	traceHeadersKey
+)
+
+// traceHeaders are the incoming headers forwarded to Elasticsearch, so its
+// logs can be correlated with the request that caused them.
+var traceHeaders = []string{"X-Opaque-Id", "traceparent", "tracestate"}
+
+// withTraceHeaders keeps the trace headers of the request in its context,
+// for traceTransport to copy onto the Elasticsearch requests it makes.
+func withTraceHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := http.Header{}
+		for _, name := range traceHeaders {
+			if v := r.Header.Get(name); v != "" {
+				h.Set(name, v)
+			}
+		}
+		if h.Get("X-Opaque-Id") == "" {
+			if id := requestID(r.Context()); id != "" {
+				h.Set("X-Opaque-Id", id)
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), traceHeadersKey, h)))
+	})
+}
+
+// withRequestID tags every request with an ID, reusing the client's
+// X-Request-Id when present, and echoes it in the response.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" {
+			id = newRequestID()
+		}
+
+		w.Header().Set("X-Request-Id", id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// withRecovery turns a panicking handler into a 500 response instead of a
+// dropped connection.
+func withRecovery(logger *log.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				logger.Printf("panic serving %s %s [%s]: %v\n%s",
+					r.Method, r.URL.Path, requestID(r.Context()), err, debug.Stack())
+				writeError(w, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withoutTrailingSlash serves /search/ like /search, for every route of mux
+// registered without a trailing slash. Paths registered with one, such as
+// the /people/ subtree, are left as they are. It runs before next so that
+// checks on the path, such as readOnlyPosts, see the rewritten one.
+func withoutTrailingSlash(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if len(p) > 1 && strings.HasSuffix(p, "/") {
+			trimmed := strings.TrimRight(p, "/")
+			if trimmed == "" {
+				trimmed = "/"
+			}
+			if routePattern(mux, r, p) != p && routePattern(mux, r, trimmed) == trimmed {
+				r = r.Clone(r.Context())
+				r.URL.Path, r.URL.RawPath = trimmed, ""
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routePattern returns the pattern of mux that would serve r at path.
+func routePattern(mux *http.ServeMux, r *http.Request, path string) string {
+	probe := r.Clone(r.Context())
+	probe.URL.Path, probe.URL.RawPath = path, ""
+	_, pattern := mux.Handler(probe)
+
+	return pattern
+}
+
+// withSlowWarning logs a WARN line for every request taking longer than
+// threshold, as it happens. It is a no-op when threshold is 0.
+func withSlowWarning(logger *log.Logger, threshold time.Duration, next http.Handler) http.Handler {
+	if threshold <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+
+		if elapsed := time.Since(start); elapsed > threshold {
+			logger.Printf("WARN slow request %s %s took %s [%s]",
+				r.Method, r.URL.RequestURI(), elapsed, requestID(r.Context()))
+		}
+	})
+}
+
+// readOnlyPosts are POST endpoints that only read data and stay open when
+// an API key is required.
+var readOnlyPosts = map[string]bool{
+	"/search":          true,
+	"/search/template": true,
+	"/people:mget":     true,
+}
+
+// withAPIKey requires "Authorization: Bearer <key>" on mutating requests.
+// It is a no-op when key is empty.
+func withAPIKey(key string, next http.Handler) http.Handler {
+	if key == "" {
+		return next
+	}
+	want := sha256.Sum256([]byte(key))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		case http.MethodPost:
+			if readOnlyPosts[r.URL.Path] {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		// Comparing digests keeps the comparison constant-time regardless of
+		// the length of the supplied token.
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		got := sha256.Sum256([]byte(token))
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") ||
+			subtle.ConstantTimeCompare(got[:], want[:]) != 1 {
+
+			w.Header().Set("WWW-Authenticate", `Bearer realm="es-demo"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
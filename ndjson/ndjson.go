@@ -0,0 +1,78 @@
+// Package ndjson reads and writes newline delimited JSON, one value per line,
+// as used by the Elasticsearch bulk API and the streamed search results.
+package ndjson
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+)
+
+// ContentType is the media type of newline delimited JSON.
+const ContentType = "application/x-ndjson"
+
+// IsContentType reports whether a Content-Type header value denotes NDJSON.
+// Both application/x-ndjson and application/ndjson are accepted.
+func IsContentType(v string) bool {
+	mediaType, _, err := mime.ParseMediaType(v)
+	return err == nil && (mediaType == ContentType || mediaType == "application/ndjson")
+}
+
+// Reader decodes one JSON value per line. Blank lines, including a trailing
+// newline or its absence at the end of the input, are skipped.
+type Reader struct {
+	r    *bufio.Reader
+	line int
+}
+
+// NewReader returns a Reader reading from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Decode decodes the next non-blank line into v. It returns io.EOF when the
+// input is exhausted.
+func (r *Reader) Decode(v interface{}) error {
+	for {
+		line, err := r.r.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return err
+		}
+		r.line++
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if jerr := json.Unmarshal(line, v); jerr != nil {
+			return fmt.Errorf("ndjson: line %d: %w", r.line, jerr)
+		}
+		return nil
+	}
+}
+
+// Writer encodes one JSON value per line.
+type Writer struct {
+	enc *json.Encoder
+}
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+
+	return &Writer{enc: enc}
+}
+
+// Encode writes v followed by a newline. Encoded JSON never contains a raw
+// newline, so every value occupies exactly one line.
+func (w *Writer) Encode(v interface{}) error {
+	return w.enc.Encode(v)
+}
@@ -0,0 +1,114 @@
+package ndjson
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+type doc struct {
+	ID string `json:"id"`
+}
+
+func decodeAll(t *testing.T, input string) ([]string, error) {
+	t.Helper()
+
+	var ids []string
+	r := NewReader(strings.NewReader(input))
+	for {
+		var d doc
+		err := r.Decode(&d)
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, d.ID)
+	}
+}
+
+func TestReaderDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"trailing newline", "{\"id\":\"1\"}\n{\"id\":\"2\"}\n"},
+		{"no trailing newline", "{\"id\":\"1\"}\n{\"id\":\"2\"}"},
+		{"extra trailing newlines", "{\"id\":\"1\"}\n{\"id\":\"2\"}\n\n\n"},
+		{"blank lines", "\n{\"id\":\"1\"}\n  \n\n{\"id\":\"2\"}\n"},
+		{"crlf", "{\"id\":\"1\"}\r\n{\"id\":\"2\"}\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ids, err := decodeAll(t, tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(ids, ",") != "1,2" {
+				t.Errorf("got ids %v, want [1 2]", ids)
+			}
+		})
+	}
+}
+
+func TestReaderDecodeEmpty(t *testing.T) {
+	for _, input := range []string{"", "\n", " \n\n"} {
+		ids, err := decodeAll(t, input)
+		if err != nil || len(ids) != 0 {
+			t.Errorf("decode %q: got %v, %v, want no values", input, ids, err)
+		}
+	}
+}
+
+func TestReaderDecodeErrorLine(t *testing.T) {
+	// Blank lines count towards the line number.
+	input := "{\"id\":\"1\"}\n\n{\"id\":\n{\"id\":\"3\"}\n"
+
+	ids, err := decodeAll(t, input)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !strings.HasPrefix(err.Error(), "ndjson: line 3: ") {
+		t.Errorf("got error %q, want it to name line 3", err)
+	}
+	if len(ids) != 1 {
+		t.Errorf("got ids %v, want the values before the error", ids)
+	}
+}
+
+func TestWriterEncode(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, v := range []interface{}{
+		doc{ID: "1"},
+		map[string]string{"note": "a\nb <c>"},
+	} {
+		if err := w.Encode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := "{\"id\":\"1\"}\n{\"note\":\"a\\nb <c>\"}\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIsContentType(t *testing.T) {
+	tests := map[string]bool{
+		"application/x-ndjson":                true,
+		"application/ndjson":                  true,
+		"application/x-ndjson; charset=utf-8": true,
+		"application/json":                    false,
+		"":                                    false,
+	}
+
+	for v, want := range tests {
+		if got := IsContentType(v); got != want {
+			t.Errorf("IsContentType(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
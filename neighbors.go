@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// personContextResponse holds a person and its neighbours in last name
+// order. Previous and Next are null at the edges.
+type personContextResponse struct {
+	Person   *Person `json:"person"`
+	Previous *Person `json:"previous"`
+	Next     *Person `json:"next"`
+}
+
+// personContext looks up a person and the records immediately before and
+// after it when sorted by last name, using search_after anchored on the
+// person's own sort values.
+func personContext(w http.ResponseWriter, r *http.Request, reads *failover, id string) {
+	es := reads.client()
+	res, err := esapi.GetRequest{Index: peopleIndex, DocumentID: id}.Do(r.Context(), es)
+	reads.report(es, res, err)
+	if err != nil {
+		writeESError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		if eserr.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("person %q not found", id))
+			return
+		}
+		writeESError(w, err)
+		return
+	}
+
+	var doc struct {
+		Source *Person `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	out := personContextResponse{Person: doc.Source}
+	anchor := []string{doc.Source.LastName, id}
+
+	if out.Previous, err = neighbor(r, reads, es, anchor, "desc"); err != nil {
+		writeESError(w, err)
+		return
+	}
+	if out.Next, err = neighbor(r, reads, es, anchor, "asc"); err != nil {
+		writeESError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(jsonOutput(w, r)).Encode(out)
+}
+
+// neighbor returns the first person after anchor in the given sort order, or
+// nil when there is none.
+func neighbor(r *http.Request, reads *failover, es *elasticsearch.Client,
+	anchor []string, order string) (*Person, error) {
+
+	body := map[string]interface{}{
+		"size":  1,
+		"query": scoped(map[string]interface{}{"match_all": map[string]interface{}{}}, includeAll(r)),
+		"sort": []interface{}{
+			map[string]interface{}{"last_name.keyword": map[string]string{"order": order, "missing": ""}},
+			map[string]interface{}{"id": map[string]string{"order": order}},
+		},
+		"search_after": anchor,
+	}
+	payload, _ := json.Marshal(body)
+
+	res, err := es.Search(
+		es.Search.WithContext(r.Context()),
+		es.Search.WithIndex(peopleIndex),
+		es.Search.WithBody(bytes.NewReader(payload)),
+	)
+	reads.report(es, res, err)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return nil, err
+	}
+
+	var result esSearchResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	if len(result.Hits.Hits) == 0 {
+		return nil, nil
+	}
+
+	return result.Hits.Hits[0].Source, nil
+}
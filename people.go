@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// personDocument is a Person together with the sequence number and primary
+// term Elasticsearch uses for optimistic concurrency control.
+type personDocument struct {
+	*Person
+	SeqNo       int `json:"_seq_no"`
+	PrimaryTerm int `json:"_primary_term"`
+}
+
+type writeResult struct {
+	ID          string `json:"id"`
+	Result      string `json:"result"`
+	SeqNo       int    `json:"_seq_no"`
+	PrimaryTerm int    `json:"_primary_term"`
+}
+
+// version identifies a document revision for optimistic concurrency.
+type version struct {
+	seqNo       int
+	primaryTerm int
+}
+
+func (v version) etag() string {
+	return fmt.Sprintf(`"%d-%d"`, v.seqNo, v.primaryTerm)
+}
+
+// activeShardsWait bounds how long a write waits for wait_for_active_shards
+// when the request carries no deadline of its own.
+const activeShardsWait = 5 * time.Second
+
+func peopleHandler(logger *log.Logger, es *elasticsearch.Client, reads *failover) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		id, action := strings.TrimPrefix(r.URL.Path, "/people/"), ""
+		if i := strings.Index(id, "/"); i >= 0 {
+			id, action = id[:i], id[i+1:]
+		}
+		if id == "" {
+			writeError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		switch action {
+		case "":
+			switch r.Method {
+			case http.MethodGet:
+				getPerson(w, r, reads, id)
+			case http.MethodPut:
+				updatePerson(w, r, es, id)
+			case http.MethodDelete:
+				deletePerson(w, r, es, id)
+			default:
+				w.Header().Set("Allow", "GET, PUT, DELETE")
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			}
+		case "similar":
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", "GET")
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			similarPeople(w, r, reads, id)
+		case "context":
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", "GET")
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			personContext(w, r, reads, id)
+		default:
+			writeError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
+
+// createPersonHandler serves POST /people. Without an id in the body the
+// server assigns a random UUID, so the stored document always carries its id.
+func createPersonHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		activeShards, err := waitForActiveShards(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		var p Person
+		if err := decodeJSONBody(w, r, &p, false); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if p.ID == "" {
+			if p.ID, err = newPersonID(); err != nil {
+				writeError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		payload, err := json.Marshal(p)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		req := esapi.CreateRequest{
+			Index:      peopleIndex,
+			DocumentID: p.ID,
+			Body:       bytes.NewReader(payload),
+		}
+		if activeShards != "" {
+			req.WaitForActiveShards = activeShards
+			req.Timeout = writeDeadline(r)
+		}
+
+		res, err := req.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		// writeResponse would report the conflict as a concurrent modification.
+		if res.StatusCode == http.StatusConflict {
+			writeError(w, http.StatusConflict, fmt.Sprintf("person %q already exists", p.ID))
+			return
+		}
+		writeResponse(w, res, p.ID)
+	}
+}
+
+// newPersonID returns a random (version 4) UUID.
+func newPersonID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func getPerson(w http.ResponseWriter, r *http.Request, reads *failover, id string) {
+	es := reads.client()
+	res, err := esapi.GetRequest{Index: peopleIndex, DocumentID: id}.Do(r.Context(), es)
+	reads.report(es, res, err)
+	if err != nil {
+		writeESError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		if eserr.IsNotFound(err) {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("person %q not found", id))
+			return
+		}
+		writeESError(w, err)
+		return
+	}
+
+	var doc struct {
+		SeqNo       int     `json:"_seq_no"`
+		PrimaryTerm int     `json:"_primary_term"`
+		Source      *Person `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", version{doc.SeqNo, doc.PrimaryTerm}.etag())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(jsonOutput(w, r)).Encode(personDocument{
+		Person:      doc.Source,
+		SeqNo:       doc.SeqNo,
+		PrimaryTerm: doc.PrimaryTerm,
+	})
+}
+
+func updatePerson(w http.ResponseWriter, r *http.Request, es *elasticsearch.Client, id string) {
+	v, err := requestVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	activeShards, err := waitForActiveShards(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var p Person
+	if err := decodeJSONBody(w, r, &p, false); err != nil {
+		writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	p.ID = id
+
+	payload, err := json.Marshal(p)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req := esapi.IndexRequest{
+		Index:      peopleIndex,
+		DocumentID: id,
+		Body:       bytes.NewReader(payload),
+	}
+	if activeShards != "" {
+		req.WaitForActiveShards = activeShards
+		req.Timeout = writeDeadline(r)
+	}
+	if v != nil {
+		req.IfSeqNo, req.IfPrimaryTerm = &v.seqNo, &v.primaryTerm
+	}
+
+	res, err := req.Do(r.Context(), es)
+	if err != nil {
+		writeESError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	writeResponse(w, res, id)
+}
+
+func deletePerson(w http.ResponseWriter, r *http.Request, es *elasticsearch.Client, id string) {
+	v, err := requestVersion(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	req := esapi.DeleteRequest{Index: peopleIndex, DocumentID: id}
+	if v != nil {
+		req.IfSeqNo, req.IfPrimaryTerm = &v.seqNo, &v.primaryTerm
+	}
+
+	res, err := req.Do(r.Context(), es)
+	if err != nil {
+		writeESError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	writeResponse(w, res, id)
+}
+
+// writeResponse translates the result of an Elasticsearch write into the API
+// response, reporting version conflicts as 409.
+func writeResponse(w http.ResponseWriter, res *esapi.Response, id string) {
+	if err := eserr.FromResponse(res); err != nil {
+		switch {
+		case eserr.IsConflict(err):
+			writeError(w, http.StatusConflict, fmt.Sprintf("person %q was modified concurrently", id))
+		case eserr.IsNotFound(err):
+			writeError(w, http.StatusNotFound, fmt.Sprintf("person %q not found", id))
+		case eserr.IsUnavailableShards(err):
+			writeError(w, http.StatusGatewayTimeout,
+				fmt.Sprintf("timed out waiting for active shards to write person %q", id))
+		default:
+			writeESError(w, err)
+		}
+		return
+	}
+
+	var result writeResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result.ID = id
+
+	w.Header().Set("ETag", version{result.SeqNo, result.PrimaryTerm}.etag())
+	writeJSON(w, res.StatusCode, result)
+}
+
+// requestVersion reads the expected document version from the seq_no and
+// primary_term query parameters or, failing that, from an If-Match header
+// carrying an ETag previously returned by the API. It returns nil when the
+// request is unconditional.
+func requestVersion(r *http.Request) (*version, error) {
+	q := r.URL.Query()
+	seqNo, primaryTerm := q.Get("seq_no"), q.Get("primary_term")
+
+	if seqNo == "" && primaryTerm == "" {
+		match := strings.Trim(r.Header.Get("If-Match"), `"`)
+		if match == "" {
+			return nil, nil
+		}
+
+		parts := strings.SplitN(match, "-", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid If-Match header %q", r.Header.Get("If-Match"))
+		}
+		seqNo, primaryTerm = parts[0], parts[1]
+	}
+
+	if seqNo == "" || primaryTerm == "" {
+		return nil, fmt.Errorf("seq_no and primary_term must be provided together")
+	}
+
+	s, err := strconv.Atoi(seqNo)
+	if err != nil || s < 0 {
+		return nil, fmt.Errorf("invalid seq_no %q", seqNo)
+	}
+	p, err := strconv.Atoi(primaryTerm)
+	if err != nil || p < 1 {
+		return nil, fmt.Errorf("invalid primary_term %q", primaryTerm)
+	}
+
+	return &version{seqNo: s, primaryTerm: p}, nil
+}
+
+// waitForActiveShards reads the wait_for_active_shards parameter, "all" or a
+// positive number of shard copies. It returns "" when absent, leaving the
+// Elasticsearch default of 1.
+func waitForActiveShards(r *http.Request) (string, error) {
+	v := r.URL.Query().Get("wait_for_active_shards")
+	if v == "" || v == "all" {
+		return v, nil
+	}
+
+	if n, err := strconv.Atoi(v); err != nil || n < 1 {
+		return "", fmt.Errorf("wait_for_active_shards must be all or a positive integer")
+	}
+
+	return v, nil
+}
+
+// writeDeadline is how long a write may wait for shard copies: the time left
+// before the request deadline, or activeShardsWait without one.
+func writeDeadline(r *http.Request) time.Duration {
+	if deadline, ok := r.Context().Deadline(); ok {
+		if left := time.Until(deadline); left > 0 {
+			return left
+		}
+	}
+
+	return activeShardsWait
+}
+
+// mgetResult is one of the people requested from POST /people:mget. Person
+// is absent when Found is false.
+type mgetResult struct {
+	ID     string          `json:"id"`
+	Found  bool            `json:"found"`
+	Person *personDocument `json:"person,omitempty"`
+}
+
+// mgetPeopleHandler serves POST /people:mget, fetching the people of
+// {"ids": [...]} in one call. Results follow the order of the ids, with the
+// missing ones marked as not found.
+func mgetPeopleHandler(logger *log.Logger, reads *failover) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body struct {
+			IDs []string `json:"ids"`
+		}
+		if err := decodeJSONBody(w, r, &body, true); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if len(body.IDs) == 0 || len(body.IDs) > maxSize {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("ids must list between 1 and %d ids", maxSize))
+			return
+		}
+
+		payload, _ := json.Marshal(map[string][]string{"ids": body.IDs})
+		es := reads.client()
+		res, err := esapi.MgetRequest{
+			Index: peopleIndex,
+			Body:  bytes.NewReader(payload),
+		}.Do(r.Context(), es)
+		reads.report(es, res, err)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		var docs struct {
+			Docs []struct {
+				ID          string  `json:"_id"`
+				Found       bool    `json:"found"`
+				SeqNo       int     `json:"_seq_no"`
+				PrimaryTerm int     `json:"_primary_term"`
+				Source      *Person `json:"_source"`
+			} `json:"docs"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&docs); err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		// Elasticsearch answers the docs in the order they were asked for.
+		out := make([]mgetResult, 0, len(docs.Docs))
+		for _, d := range docs.Docs {
+			result := mgetResult{ID: d.ID, Found: d.Found}
+			if d.Found {
+				result.Person = &personDocument{Person: d.Source, SeqNo: d.SeqNo, PrimaryTerm: d.PrimaryTerm}
+			}
+			out = append(out, result)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(jsonOutput(w, r)).Encode(out)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+)
+
+const prettyIndent = "  "
+
+// jsonOutput returns w, wrapped to re-indent the JSON written to it when the
+// request asks for pretty=true and to camelCase its field names when it asks
+// for case=camel.
+func jsonOutput(w io.Writer, r *http.Request) io.Writer {
+	if r.URL.Query().Get("pretty") == "true" {
+		w = &prettyWriter{w: w}
+	}
+	if camelCase(r) {
+		w = &camelWriter{w: w}
+	}
+
+	return w
+}
+
+// prettyWriter indents compact JSON as it streams through, so large
+// responses don't have to be buffered to be pretty printed.
+type prettyWriter struct {
+	w io.Writer
+
+	depth    int
+	inString bool
+	escaped  bool
+	// opened is set after { or [ until the next token shows whether the
+	// container is empty.
+	opened bool
+}
+
+func (p *prettyWriter) Write(b []byte) (int, error) {
+	var out strings.Builder
+	for _, c := range b {
+		if p.inString {
+			out.WriteByte(c)
+			switch {
+			case p.escaped:
+				p.escaped = false
+			case c == '\\':
+				p.escaped = true
+			case c == '"':
+				p.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case ' ', '\t', '\r':
+			continue
+		case '\n':
+			if p.depth == 0 && !p.opened {
+				out.WriteByte(c)
+			}
+			continue
+		}
+
+		if p.opened {
+			p.opened = false
+			if c == '}' || c == ']' {
+				p.depth--
+				out.WriteByte(c)
+				continue
+			}
+			p.newline(&out)
+		}
+
+		switch c {
+		case '{', '[':
+			out.WriteByte(c)
+			p.depth++
+			p.opened = true
+		case '}', ']':
+			p.depth--
+			p.newline(&out)
+			out.WriteByte(c)
+		case ',':
+			out.WriteByte(c)
+			p.newline(&out)
+		case ':':
+			out.WriteString(": ")
+		case '"':
+			p.inString = true
+			out.WriteByte(c)
+		default:
+			out.WriteByte(c)
+		}
+	}
+
+	if _, err := io.WriteString(p.w, out.String()); err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (p *prettyWriter) newline(out *strings.Builder) {
+	out.WriteByte('\n')
+	out.WriteString(strings.Repeat(prettyIndent, p.depth))
+}
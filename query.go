@@ -0,0 +1,906 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type searchField struct {
+	Name  string
+	Boost float64
+	// Operator combines the terms of the query matched against the field,
+	// "and" requiring all of them and "or" any.
+	Operator string
+}
+
+// searchFields lists the fields queried and highlighted by the search query,
+// with their default boosts and operators.
+var searchFields = []searchField{
+	{Name: "last_name", Boost: 100, Operator: "and"},
+	{Name: "first_name", Boost: 10, Operator: "and"},
+	{Name: "country", Boost: 1, Operator: "and"},
+	{Name: "title", Boost: 1, Operator: "and"},
+	{Name: "address.city", Boost: 1, Operator: "and"},
+	{Name: "full_name", Boost: 1, Operator: "and"},
+}
+
+// highlightSubFields lists, per search field, the mapped sub-fields whose
+// matches can be combined into its highlights with matched_fields. These and
+// their parent fields are mapped with term vectors, which the fast vector
+// highlighter used for matched_fields requires.
+var highlightSubFields = map[string][]string{
+	"country":      {"country.plain"},
+	"address.city": {"address.city.plain"},
+}
+
+// fullNameScript computes "<first_name> <last_name>" at query time for
+// sorting. full_name itself is only indexed through copy_to, which cannot be
+// sorted on.
+const fullNameScript = `String f = doc['first_name.keyword'].size() == 0 ? '' : doc['first_name.keyword'].value;
+String l = doc['last_name.keyword'].size() == 0 ? '' : doc['last_name.keyword'].value;
+return (f + ' ' + l).trim();`
+
+func isSearchField(name string) bool {
+	for _, f := range searchFields {
+		if f.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// setFieldBoost changes the default boost of a search field.
+func setFieldBoost(name string, boost float64) {
+	for i := range searchFields {
+		if searchFields[i].Name == name {
+			searchFields[i].Boost = boost
+		}
+	}
+}
+
+// fieldOperator returns the operator of the named search field.
+func fieldOperator(name string) string {
+	for _, f := range searchFields {
+		if f.Name == name {
+			return f.Operator
+		}
+	}
+
+	return "and"
+}
+
+// setFieldOperators changes the operators of search fields, given as
+// field:operator pairs such as country:or,title:or.
+func setFieldOperators(v string) error {
+	if v == "" {
+		return nil
+	}
+
+	for _, setting := range strings.Split(v, ",") {
+		i := strings.Index(setting, ":")
+		if i < 0 {
+			return fmt.Errorf("invalid operator %q, expected field:operator", setting)
+		}
+
+		name, op := setting[:i], setting[i+1:]
+		if !isSearchField(name) {
+			return fmt.Errorf("cannot set operator of unknown field %q", name)
+		}
+		if op != "and" && op != "or" {
+			return fmt.Errorf("invalid operator %q for %q, expected and or or", op, name)
+		}
+		for i := range searchFields {
+			if searchFields[i].Name == name {
+				searchFields[i].Operator = op
+			}
+		}
+	}
+
+	return nil
+}
+
+const (
+	defaultSize = 25
+	maxSize     = 100
+	// maxResultWindow mirrors the index.max_result_window default.
+	maxResultWindow = 10000
+	maxSearchBody   = 1 << 20
+	// aggBuckets is the number of buckets returned per aggregation.
+	aggBuckets = 10
+)
+
+// filterFields maps the fields results can be filtered on to the keyword
+// field holding their exact value.
+var filterFields = map[string]string{
+	"country":      "country.keyword",
+	"title":        "title.keyword",
+	"email":        "email.keyword",
+	"address.city": "address.city.keyword",
+}
+
+// lowercaseFields maps the filterFields to their sub-field normalized to
+// lowercase, which exact-value filters match so that country=neverland
+// matches Neverland. The keyword fields keep the original values for
+// aggregations and collapsing.
+var lowercaseFields = map[string]string{
+	"country":      "country.lowercase",
+	"title":        "title.lowercase",
+	"email":        "email.lowercase",
+	"address.city": "address.city.lowercase",
+}
+
+// termFilter matches the exact value of the filterFields name ignoring case.
+func termFilter(name, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"term": map[string]interface{}{lowercaseFields[name]: strings.ToLower(value)},
+	}
+}
+
+// defaultFilter is the -default-filter clause scoping every search, for
+// example to exclude soft-deleted people, or nil when unset.
+var defaultFilter map[string]interface{}
+
+// loadDefaultFilter parses the -default-filter query clause.
+func loadDefaultFilter(v string) (map[string]interface{}, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	var clause map[string]interface{}
+	if err := json.Unmarshal([]byte(v), &clause); err != nil {
+		return nil, err
+	}
+	if len(clause) != 1 {
+		return nil, fmt.Errorf("expected a single query clause, got %d keys", len(clause))
+	}
+
+	return clause, nil
+}
+
+// scoped restricts query to the documents matching defaultFilter, unless
+// includeAll is set with include_all=true.
+func scoped(query map[string]interface{}, includeAll bool) map[string]interface{} {
+	if defaultFilter == nil || includeAll {
+		return query
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{"must": query, "filter": defaultFilter},
+	}
+}
+
+// includeAll reports whether the request opts out of defaultFilter.
+func includeAll(r *http.Request) bool {
+	return r.URL.Query().Get("include_all") == "true"
+}
+
+// searchAnalyzers are the analyzers a search may override the fields' own
+// with: the built-in ones and those defined by indexSettings.
+var searchAnalyzers = map[string]bool{
+	"standard":         true,
+	"simple":           true,
+	"whitespace":       true,
+	"keyword":          true,
+	"stop":             true,
+	"country_analyzer": true,
+}
+
+// languages maps the codes accepted by the lang parameter to the analyzer of
+// their language sub-fields, as configured by -languages.
+var languages map[string]string
+
+// languageFields are the search fields carrying a sub-field per language.
+var languageFields = map[string]bool{
+	"last_name":  true,
+	"first_name": true,
+	"title":      true,
+}
+
+// phoneticFields are the name search fields carrying the phonetic sub-field
+// of -phonetic.
+var phoneticFields = map[string]bool{
+	"last_name":  true,
+	"first_name": true,
+}
+
+// boundaryScanners are the accepted values of the boundary_scanner
+// parameter.
+var boundaryScanners = map[string]bool{
+	"chars":    true,
+	"sentence": true,
+	"word":     true,
+}
+
+var validLocale = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+
+var validLanguage = regexp.MustCompile(`^[a-z]{2,8}$`)
+
+// parseLanguages parses -languages, code:analyzer pairs such as
+// en:english,de:german.
+func parseLanguages(v string) (map[string]string, error) {
+	if v == "" {
+		return nil, nil
+	}
+
+	langs := map[string]string{}
+	for _, setting := range strings.Split(v, ",") {
+		i := strings.Index(setting, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid language %q, expected code:analyzer", setting)
+		}
+
+		code, analyzer := setting[:i], setting[i+1:]
+		if !validLanguage.MatchString(code) {
+			return nil, fmt.Errorf("invalid language code %q", code)
+		}
+		if analyzer == "" {
+			return nil, fmt.Errorf("missing analyzer of language %q", code)
+		}
+		langs[code] = analyzer
+	}
+
+	return langs, nil
+}
+
+// searchTypes are the accepted values of the search_type parameter.
+var searchTypes = map[string]bool{
+	"query_then_fetch":     true,
+	"dfs_query_then_fetch": true,
+}
+
+// validPreference matches custom preference strings such as session IDs.
+// Values starting with an underscore are reserved by Elasticsearch.
+var validPreference = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:-]{0,127}$`)
+
+// searchQuery holds the user-controlled parts of a search.
+type searchQuery struct {
+	Text string
+	// Boosts overrides the default boost of a field for this search only.
+	Boosts map[string]float64
+
+	SearchType string
+	// TrackTotalHits is true, false or the hit count up to which the total
+	// is counted exactly.
+	TrackTotalHits interface{}
+	// Fuzziness enables fuzzy matching per field, keyed by field name.
+	Fuzziness map[string]string
+	// HighlightFields lists the fields to highlight, all search fields when
+	// empty.
+	HighlightFields []string
+	// MatchedFields lists per highlighted field the sub-fields whose matches
+	// are merged into its highlights.
+	MatchedFields map[string][]string
+	// HighlightOffsets reports the character offsets of the highlighted
+	// matches instead of marked-up fragments.
+	HighlightOffsets bool
+	// BoundaryScanner, one of boundaryScanners, breaks highlights into
+	// snippets at natural boundaries instead of returning whole values.
+	// BoundaryScannerLocale is the locale of sentence and word boundaries.
+	BoundaryScanner       string
+	BoundaryScannerLocale string
+	// RequireFieldMatch limits highlighting to the fields that matched.
+	RequireFieldMatch bool
+	// Sort lists the sort keys in order, each "score" or "full_name".
+	Sort []string
+	// Filters restricts results to exact values of filterFields.
+	Filters map[string]string
+	From    int
+	Size    int
+	// Preference pins the search to the same shard copies across requests.
+	Preference string
+	// Aggs lists the filterFields to count values of. Combined with a size
+	// of 0 only the counts are fetched.
+	Aggs []string
+	// AggMinCount leaves out the aggregation buckets of fewer people.
+	AggMinCount int
+	// Explain asks Elasticsearch how the score of every hit was computed.
+	Explain bool
+	// IDsOnly fetches the IDs and scores of the hits without their source.
+	IDsOnly bool
+	// Analyzer overrides the search analyzer of the fields matched against
+	// Text.
+	Analyzer string
+	// Phonetic matches Text against the phonetic sub-fields of the names, so
+	// names that sound alike match.
+	Phonetic bool
+	// Lang, one of languages, matches Text against the language sub-fields
+	// of languageFields instead of the standard analyzed fields.
+	Lang string
+	// TerminateAfter stops each shard after collecting this many documents,
+	// 0 collects all of them.
+	TerminateAfter int
+	// IncludeAll lifts the -default-filter restriction.
+	IncludeAll bool
+	// Collapse is the filterFields key results are deduplicated on, keeping
+	// the best hit per value. CollapseCounts adds the size of each group.
+	Collapse       string
+	CollapseCounts bool
+	// Should, Must and MustNot are field-specific clauses combined into the
+	// bool query. Should clauses are alternatives to the Text match.
+	Should  []searchClause
+	Must    []searchClause
+	MustNot []searchClause
+}
+
+// searchClause matches Query against a single search field.
+type searchClause struct {
+	Field string `json:"field"`
+	Query string `json:"query"`
+}
+
+func (c searchClause) match() map[string]interface{} {
+	return map[string]interface{}{
+		"match": map[string]interface{}{
+			c.Field: map[string]interface{}{"query": c.Query, "operator": fieldOperator(c.Field)},
+		},
+	}
+}
+
+// newSearchQuery returns a search for text with default settings.
+func newSearchQuery(text string) searchQuery {
+	return searchQuery{
+		Text:              text,
+		Boosts:            map[string]float64{},
+		TrackTotalHits:    true,
+		RequireFieldMatch: true,
+		Sort:              []string{"score"},
+		Filters:           map[string]string{},
+		Fuzziness:         map[string]string{},
+		MatchedFields:     map[string][]string{},
+		Size:              defaultSize,
+		AggMinCount:       1,
+	}
+}
+
+// searchBody is the JSON body accepted by POST /search.
+type searchBody struct {
+	Q       *string           `json:"q"`
+	Filters map[string]string `json:"filters"`
+	Sort    []string          `json:"sort"`
+	From    *int              `json:"from"`
+	Size    *int              `json:"size"`
+	Aggs    []string          `json:"aggs"`
+	Should  []searchClause    `json:"should"`
+	Must    []searchClause    `json:"must"`
+	MustNot []searchClause    `json:"must_not"`
+}
+
+func parseSearchQuery(w http.ResponseWriter, r *http.Request) (searchQuery, error) {
+	q := r.URL.Query()
+	sq := newSearchQuery(normalizeQuery(q.Get("q")))
+	sq.IncludeAll = includeAll(r)
+
+	if v := q.Get("country_boost"); v != "" {
+		boost, err := strconv.ParseFloat(v, 64)
+		if err != nil || boost < 0 {
+			return sq, fmt.Errorf("country_boost must be a non-negative number")
+		}
+		sq.Boosts["country"] = boost
+	}
+
+	if sq.SearchType = q.Get("search_type"); sq.SearchType != "" && !searchTypes[sq.SearchType] {
+		return sq, fmt.Errorf("invalid search_type %q", sq.SearchType)
+	}
+
+	if v := q.Get("sort"); v != "" {
+		sq.Sort = strings.Split(v, ",")
+	}
+
+	for name := range filterFields {
+		if v := q.Get(name); v != "" {
+			sq.Filters[name] = v
+		}
+	}
+
+	var err error
+	if sq.From, err = nonNegativeParam(q.Get("from"), "from", 0); err != nil {
+		return sq, err
+	}
+	if sq.Size, err = nonNegativeParam(q.Get("size"), "size", defaultSize); err != nil {
+		return sq, err
+	}
+
+	if v := q.Get("aggs"); v != "" {
+		sq.Aggs = strings.Split(v, ",")
+	}
+	if sq.AggMinCount, err = intParam(r, "min_count", 1); err != nil {
+		return sq, err
+	}
+
+	if v := q.Get("fuzziness"); v != "" {
+		if err := parseFuzziness(v, sq.Fuzziness); err != nil {
+			return sq, err
+		}
+	}
+
+	if v := q.Get("highlight_fields"); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if !isSearchField(name) {
+				return sq, fmt.Errorf("cannot highlight unknown field %q", name)
+			}
+			sq.HighlightFields = append(sq.HighlightFields, name)
+		}
+	}
+
+	if sq.Analyzer = q.Get("analyzer"); sq.Analyzer != "" && !searchAnalyzers[sq.Analyzer] {
+		return sq, fmt.Errorf("unknown analyzer %q", sq.Analyzer)
+	}
+
+	if v := q.Get("phonetic"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("phonetic must be true or false")
+		}
+		if b && !phoneticNames {
+			return sq, fmt.Errorf("phonetic matching is not enabled on this server, see -phonetic")
+		}
+		sq.Phonetic = b
+	}
+
+	if sq.Lang = q.Get("lang"); sq.Lang != "" && languages[sq.Lang] == "" {
+		return sq, fmt.Errorf("unsupported lang %q", sq.Lang)
+	}
+
+	if sq.TerminateAfter, err = nonNegativeParam(q.Get("terminate_after"), "terminate_after", 0); err != nil {
+		return sq, err
+	}
+
+	sq.Collapse = q.Get("collapse")
+	if v := q.Get("collapse_counts"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("collapse_counts must be true or false")
+		}
+		sq.CollapseCounts = b
+	}
+
+	if v := q.Get("explain"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("explain must be true or false")
+		}
+		sq.Explain = b
+	}
+
+	if v := q.Get("ids_only"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("ids_only must be true or false")
+		}
+		sq.IDsOnly = b
+	}
+
+	if v := q.Get("matched_fields"); v != "" {
+		if err := parseMatchedFields(v, sq.MatchedFields); err != nil {
+			return sq, err
+		}
+	}
+
+	if v := q.Get("highlight_offsets"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("highlight_offsets must be true or false")
+		}
+		sq.HighlightOffsets = b
+	}
+
+	if sq.BoundaryScanner = q.Get("boundary_scanner"); sq.BoundaryScanner != "" {
+		if !boundaryScanners[sq.BoundaryScanner] {
+			return sq, fmt.Errorf("invalid boundary_scanner %q, expected chars, sentence or word", sq.BoundaryScanner)
+		}
+		if sq.HighlightOffsets {
+			return sq, fmt.Errorf("boundary_scanner can't be combined with highlight_offsets")
+		}
+	}
+	if sq.BoundaryScannerLocale = q.Get("boundary_scanner_locale"); sq.BoundaryScannerLocale != "" {
+		if !validLocale.MatchString(sq.BoundaryScannerLocale) {
+			return sq, fmt.Errorf("invalid boundary_scanner_locale %q", sq.BoundaryScannerLocale)
+		}
+		if sq.BoundaryScanner == "" {
+			return sq, fmt.Errorf("boundary_scanner_locale requires boundary_scanner")
+		}
+	}
+
+	if v := q.Get("require_field_match"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return sq, fmt.Errorf("require_field_match must be true or false")
+		}
+		sq.RequireFieldMatch = b
+	}
+
+	switch v := q.Get("track_total"); v {
+	case "", "true":
+	case "false":
+		sq.TrackTotalHits = false
+	default:
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return sq, fmt.Errorf("track_total must be true, false or a non-negative integer")
+		}
+		sq.TrackTotalHits = n
+	}
+
+	if sq.Preference = q.Get("preference"); sq.Preference != "" && !validPreference.MatchString(sq.Preference) {
+		return sq, fmt.Errorf("invalid preference %q", sq.Preference)
+	}
+
+	if r.Method == http.MethodPost {
+		if err := decodeSearchBody(w, r, &sq); err != nil {
+			return sq, err
+		}
+	}
+
+	return sq, validateSearchQuery(sq)
+}
+
+// decodeSearchBody applies the fields present in a POST /search body on top
+// of the query parameters.
+func decodeSearchBody(w http.ResponseWriter, r *http.Request, sq *searchQuery) error {
+	var body searchBody
+	if err := decodeJSONBody(w, r, &body, true); err != nil {
+		return fmt.Errorf("invalid search body: %w", err)
+	}
+
+	if body.Q != nil {
+		sq.Text = normalizeQuery(*body.Q)
+	}
+	for name, value := range body.Filters {
+		sq.Filters[name] = value
+	}
+	if body.Sort != nil {
+		sq.Sort = body.Sort
+	}
+	if body.From != nil {
+		sq.From = *body.From
+	}
+	if body.Size != nil {
+		sq.Size = *body.Size
+	}
+	if body.Aggs != nil {
+		sq.Aggs = body.Aggs
+	}
+	sq.Should, sq.Must, sq.MustNot = body.Should, body.Must, body.MustNot
+
+	return nil
+}
+
+func validateSearchQuery(sq searchQuery) error {
+	for _, s := range sq.Sort {
+		if s != "score" && s != "full_name" {
+			return fmt.Errorf("invalid sort %q, expected score or full_name", s)
+		}
+	}
+
+	for name := range sq.Filters {
+		if _, ok := filterFields[name]; !ok {
+			return fmt.Errorf("cannot filter on %q", name)
+		}
+	}
+
+	for _, clauses := range [][]searchClause{sq.Should, sq.Must, sq.MustNot} {
+		for _, c := range clauses {
+			if !isSearchField(c.Field) {
+				return fmt.Errorf("cannot search unknown field %q", c.Field)
+			}
+			if normalizeQuery(c.Query) == "" {
+				return fmt.Errorf("empty query for field %q", c.Field)
+			}
+		}
+	}
+
+	// Only keyword fields can be collapsed on, which all filterFields are.
+	if _, ok := filterFields[sq.Collapse]; sq.Collapse != "" && !ok {
+		return fmt.Errorf("cannot collapse on %q", sq.Collapse)
+	}
+	if sq.CollapseCounts && sq.Collapse == "" {
+		return fmt.Errorf("collapse_counts requires collapse")
+	}
+
+	for _, name := range sq.Aggs {
+		if _, ok := filterFields[name]; !ok {
+			return fmt.Errorf("cannot aggregate on %q", name)
+		}
+	}
+
+	switch {
+	case sq.From < 0:
+		return fmt.Errorf("from must not be negative")
+	case sq.Size < 0 || sq.Size > maxSize:
+		return fmt.Errorf("size must be between 0 and %d", maxSize)
+	case sq.From+sq.Size > maxResultWindow:
+		return fmt.Errorf("from + size must not exceed %d", maxResultWindow)
+	}
+
+	return nil
+}
+
+// parseMatchedFields parses comma separated field:sub-field pairs, such as
+// country:country.plain, into out. A field may be listed more than once.
+func parseMatchedFields(v string, out map[string][]string) error {
+	for _, pair := range strings.Split(v, ",") {
+		i := strings.Index(pair, ":")
+		if i < 0 {
+			return fmt.Errorf("invalid matched_fields %q, expected field:sub-field", pair)
+		}
+
+		name, sub := pair[:i], pair[i+1:]
+		if !isSearchField(name) {
+			return fmt.Errorf("cannot highlight unknown field %q", name)
+		}
+
+		known := false
+		for _, s := range highlightSubFields[name] {
+			known = known || s == sub
+		}
+		if !known {
+			return fmt.Errorf("%q is not a sub-field of %q", sub, name)
+		}
+		out[name] = append(out[name], sub)
+	}
+
+	return nil
+}
+
+// validFuzziness matches the fuzziness values accepted by Elasticsearch.
+var validFuzziness = regexp.MustCompile(`^(0|1|2|AUTO(:\d+,\d+)?)$`)
+
+// parseFuzziness parses either a single fuzziness applied to every search
+// field, e.g. "AUTO", or per-field settings such as "last_name:AUTO,title:1".
+// Fields without a setting are matched exactly.
+func parseFuzziness(v string, out map[string]string) error {
+	if validFuzziness.MatchString(v) {
+		for _, f := range searchFields {
+			out[f.Name] = v
+		}
+		return nil
+	}
+
+	for _, setting := range strings.Split(v, ",") {
+		i := strings.Index(setting, ":")
+		if i < 0 {
+			return fmt.Errorf("invalid fuzziness %q, expected field:value", setting)
+		}
+
+		name, value := setting[:i], setting[i+1:]
+		if !isSearchField(name) {
+			return fmt.Errorf("cannot set fuzziness of unknown field %q", name)
+		}
+		if !validFuzziness.MatchString(value) {
+			return fmt.Errorf("invalid fuzziness %q for %q", value, name)
+		}
+		out[name] = value
+	}
+
+	return nil
+}
+
+func nonNegativeParam(v, name string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+
+	return n, nil
+}
+
+// normalizeQuery trims the query, collapses runs of whitespace into single
+// spaces and, with -lowercase-query, lowercases it.
+func normalizeQuery(q string) string {
+	q = strings.Join(strings.Fields(q), " ")
+	if lowercaseQuery {
+		q = strings.ToLower(q)
+	}
+
+	return q
+}
+
+// buildQuery returns the JSON search request body for sq.
+func buildQuery(sq searchQuery) io.Reader {
+	payload, _ := json.Marshal(queryBody(sq))
+	return bytes.NewReader(payload)
+}
+
+// queryBody builds the search request body for sq as a JSON document, so the
+// query contract can be inspected without going through a reader.
+func queryBody(sq searchQuery) map[string]interface{} {
+	should := make([]interface{}, 0, len(searchFields))
+	highlight := make(map[string]interface{}, len(searchFields))
+	for _, f := range searchFields {
+		boost := f.Boost
+		if b, ok := sq.Boosts[f.Name]; ok {
+			boost = b
+		}
+
+		match := map[string]interface{}{
+			"query":    sq.Text,
+			"operator": f.Operator,
+			"boost":    boost,
+		}
+		if fuzziness, ok := sq.Fuzziness[f.Name]; ok {
+			match["fuzziness"] = fuzziness
+		}
+		if sq.Analyzer != "" {
+			match["analyzer"] = sq.Analyzer
+		}
+		name := queriedField(sq, f.Name)
+		should = append(should, map[string]interface{}{
+			"match": map[string]interface{}{name: match},
+		})
+
+		if len(sq.HighlightFields) == 0 {
+			highlight[name] = highlightField(sq, f.Name)
+		}
+	}
+	for _, name := range sq.HighlightFields {
+		highlight[queriedField(sq, name)] = highlightField(sq, name)
+	}
+
+	boolQuery := map[string]interface{}{}
+	structured := len(sq.Should)+len(sq.Must)+len(sq.MustNot) > 0
+	if sq.Text == "" && (len(sq.Filters) > 0 || structured) {
+		should = should[:0]
+	}
+	for _, c := range sq.Should {
+		should = append(should, c.match())
+	}
+	if len(should) > 0 {
+		boolQuery["should"] = should
+		boolQuery["minimum_should_match"] = 1
+	} else if len(sq.Must) == 0 && len(sq.Filters) == 0 {
+		// Only must_not clauses: match everything except their matches.
+		boolQuery["must"] = map[string]interface{}{"match_all": map[string]interface{}{}}
+	}
+	if len(sq.Must) > 0 {
+		must := make([]interface{}, 0, len(sq.Must))
+		for _, c := range sq.Must {
+			must = append(must, c.match())
+		}
+		boolQuery["must"] = must
+	}
+	if len(sq.MustNot) > 0 {
+		mustNot := make([]interface{}, 0, len(sq.MustNot))
+		for _, c := range sq.MustNot {
+			mustNot = append(mustNot, c.match())
+		}
+		boolQuery["must_not"] = mustNot
+	}
+
+	if len(sq.Filters) > 0 {
+		names := make([]string, 0, len(sq.Filters))
+		for name := range sq.Filters {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		filters := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			filters = append(filters, termFilter(name, sq.Filters[name]))
+		}
+		boolQuery["filter"] = filters
+	}
+
+	body := map[string]interface{}{
+		"query": scoped(map[string]interface{}{"bool": boolQuery}, sq.IncludeAll),
+		"size":  sq.Size,
+	}
+
+	// Without hits there is nothing to sort or highlight, and a script sort
+	// would still be evaluated for every match.
+	if sq.Size > 0 {
+		if sq.IDsOnly {
+			body["_source"] = false
+		} else {
+			hl := map[string]interface{}{
+				"fields":              highlight,
+				"require_field_match": sq.RequireFieldMatch,
+			}
+			if sq.HighlightOffsets {
+				hl["pre_tags"] = []string{highlightStart}
+				hl["post_tags"] = []string{highlightEnd}
+			} else {
+				// Fragments are marked up with <em>, so escape the source
+				// text around the tags for them to be safe as HTML.
+				hl["encoder"] = "html"
+			}
+			if sq.BoundaryScanner != "" {
+				hl["boundary_scanner"] = sq.BoundaryScanner
+				if sq.BoundaryScannerLocale != "" {
+					hl["boundary_scanner_locale"] = sq.BoundaryScannerLocale
+				}
+			}
+			body["highlight"] = hl
+		}
+		body["from"] = sq.From
+		body["sort"] = buildSort(sq.Sort)
+
+		if sq.Collapse != "" {
+			collapse := map[string]interface{}{"field": filterFields[sq.Collapse]}
+			if sq.CollapseCounts {
+				// An empty inner hits page still carries the group total.
+				collapse["inner_hits"] = map[string]interface{}{"name": "group", "size": 0}
+			}
+			body["collapse"] = collapse
+		}
+	}
+
+	if len(sq.Aggs) > 0 {
+		aggs := make(map[string]interface{}, len(sq.Aggs))
+		for _, name := range sq.Aggs {
+			aggs[name] = map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field":         filterFields[name],
+					"size":          aggBuckets,
+					"min_doc_count": sq.AggMinCount,
+				},
+			}
+		}
+		body["aggs"] = aggs
+	}
+
+	return body
+}
+
+// queriedField returns the field searched for the named search field: its
+// phonetic sub-field with -phonetic, its language sub-field for a lang, or
+// the field itself. Highlights are keyed by it, as only the searched field
+// has matches to highlight.
+func queriedField(sq searchQuery, name string) string {
+	if phoneticFields[name] && sq.Phonetic {
+		return name + ".phonetic"
+	}
+	if languageFields[name] && sq.Lang != "" {
+		return name + "." + sq.Lang
+	}
+
+	return name
+}
+
+// highlightField returns the highlight settings of the named field. Fields
+// with matched_fields need the fast vector highlighter, the only one
+// supporting it.
+func highlightField(sq searchQuery, name string) map[string]interface{} {
+	field := map[string]interface{}{}
+	if sq.BoundaryScanner == "" {
+		// Highlight whole values; a boundary scanner asks for snippets.
+		field["number_of_fragments"] = 0
+	}
+	if subs := sq.MatchedFields[name]; len(subs) > 0 {
+		field["type"] = "fvh"
+		field["matched_fields"] = append([]string{name}, subs...)
+	}
+
+	return field
+}
+
+func buildSort(keys []string) []interface{} {
+	clauses := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		switch key {
+		case "score":
+			clauses = append(clauses, map[string]string{"_score": "desc"})
+		case "full_name":
+			clauses = append(clauses, map[string]interface{}{
+				"_script": map[string]interface{}{
+					"type":   "string",
+					"order":  "asc",
+					"script": map[string]string{"lang": "painless", "source": fullNameScript},
+				},
+			})
+		}
+	}
+
+	return append(clauses, map[string]string{"_doc": "asc"})
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTermFilter(t *testing.T) {
+	tests := []struct {
+		name, value string
+		want        string
+	}{
+		{"country", "NeverLand", `{"term":{"country.lowercase":"neverland"}}`},
+		{"email", "Rob@Example.COM", `{"term":{"email.lowercase":"rob@example.com"}}`},
+		{"address.city", "new york", `{"term":{"address.city.lowercase":"new york"}}`},
+	}
+	for _, tt := range tests {
+		got, _ := json.Marshal(termFilter(tt.name, tt.value))
+		if string(got) != tt.want {
+			t.Errorf("termFilter(%q, %q) = %s, want %s", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
+
+// TestFilterFieldsLowercase checks every filter field has a lowercase
+// sub-field to be matched on.
+func TestFilterFieldsLowercase(t *testing.T) {
+	for name := range filterFields {
+		if lowercaseFields[name] == "" {
+			t.Errorf("filter field %q has no lowercase field", name)
+		}
+	}
+}
+
+// jsonAt returns the JSON of the element of v at the slash separated path,
+// where numbers index arrays, or "" when there is none. The empty path is v
+// itself.
+func jsonAt(t *testing.T, v interface{}, path string) string {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node interface{}
+	json.Unmarshal(b, &node)
+
+	for _, key := range strings.Split(path, "/") {
+		if path == "" {
+			break
+		}
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i >= len(n) {
+				return ""
+			}
+			node = n[i]
+		default:
+			return ""
+		}
+	}
+	if node == nil {
+		return ""
+	}
+
+	b, _ = json.Marshal(node)
+	return string(b)
+}
+
+func TestQueryBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		setup func(*searchQuery)
+		path  string
+		want  string
+	}{
+		{
+			name: "text matches boosted names",
+			text: "rob",
+			path: "query/bool/should/0",
+			want: `{"match":{"last_name":{"boost":100,"operator":"and","query":"rob"}}}`,
+		},
+		{
+			name: "text is required to match",
+			text: "rob",
+			path: "query/bool/minimum_should_match",
+			want: `1`,
+		},
+		{
+			name:  "field boost override",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Boosts["country"] = 0.1 },
+			path:  "query/bool/should/2/match/country/boost",
+			want:  `0.1`,
+		},
+		{
+			name: "empty query matches nothing",
+			path: "query",
+			want: `{"bool":{"minimum_should_match":1,"should":[` +
+				`{"match":{"last_name":{"boost":100,"operator":"and","query":""}}},` +
+				`{"match":{"first_name":{"boost":10,"operator":"and","query":""}}},` +
+				`{"match":{"country":{"boost":1,"operator":"and","query":""}}},` +
+				`{"match":{"title":{"boost":1,"operator":"and","query":""}}},` +
+				`{"match":{"address.city":{"boost":1,"operator":"and","query":""}}},` +
+				`{"match":{"full_name":{"boost":1,"operator":"and","query":""}}}]}}`,
+		},
+		{
+			name: "special characters are matched literally",
+			text: `O'Brien-Smith "Jr." (+*?\)`,
+			path: "query/bool/should/0",
+			want: `{"match":{"last_name":{"boost":100,"operator":"and","query":"O'Brien-Smith \"Jr.\" (+*?\\)"}}}`,
+		},
+		{
+			name:  "fuzziness of a field",
+			text:  "pjke",
+			setup: func(sq *searchQuery) { sq.Fuzziness["last_name"] = "AUTO" },
+			path:  "query/bool/should/0",
+			want:  `{"match":{"last_name":{"boost":100,"fuzziness":"AUTO","operator":"and","query":"pjke"}}}`,
+		},
+		{
+			name:  "fuzziness leaves other fields exact",
+			text:  "pjke",
+			setup: func(sq *searchQuery) { sq.Fuzziness["last_name"] = "AUTO" },
+			path:  "query/bool/should/1",
+			want:  `{"match":{"first_name":{"boost":10,"operator":"and","query":"pjke"}}}`,
+		},
+		{
+			name: "default sort",
+			text: "rob",
+			path: "sort",
+			want: `[{"_score":"desc"},{"_doc":"asc"}]`,
+		},
+		{
+			name:  "sort keys in order",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Sort = []string{"full_name", "score"} },
+			path:  "sort",
+			want: `[{"_script":{"order":"asc","script":{"lang":"painless","source":` +
+				strconv.Quote(fullNameScript) + `},"type":"string"}},{"_score":"desc"},{"_doc":"asc"}]`,
+		},
+		{
+			name: "filter with size 0 only counts",
+			setup: func(sq *searchQuery) {
+				sq.Filters["country"] = "Neverland"
+				sq.Size = 0
+			},
+			path: "",
+			want: `{"query":{"bool":{"filter":[{"term":{"country.lowercase":"neverland"}}]}},"size":0}`,
+		},
+		{
+			name:  "size",
+			setup: func(sq *searchQuery) { sq.Size = 10 },
+			path:  "size",
+			want:  `10`,
+		},
+		{
+			name:  "from",
+			setup: func(sq *searchQuery) { sq.From = 20 },
+			path:  "from",
+			want:  `20`,
+		},
+		{
+			name: "filters are lowercase terms in name order",
+			setup: func(sq *searchQuery) {
+				sq.Filters["title"] = "Dr."
+				sq.Filters["country"] = "Holland"
+			},
+			path: "query/bool/filter",
+			want: `[{"term":{"country.lowercase":"holland"}},{"term":{"title.lowercase":"dr."}}]`,
+		},
+		{
+			name:  "filters without text drop the text match",
+			setup: func(sq *searchQuery) { sq.Filters["country"] = "Holland" },
+			path:  "query/bool/should",
+			want:  ``,
+		},
+		{
+			name: "highlight of a searched field",
+			text: "rob",
+			path: "highlight/fields/last_name",
+			want: `{"number_of_fragments":0}`,
+		},
+		{
+			name: "highlight is html encoded",
+			text: "rob",
+			path: "highlight/encoder",
+			want: `"html"`,
+		},
+		{
+			name:  "highlight offsets are not html encoded",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.HighlightOffsets = true },
+			path:  "highlight/encoder",
+			want:  ``,
+		},
+		{
+			name:  "highlight_fields",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.HighlightFields = []string{"title"} },
+			path:  "highlight/fields",
+			want:  `{"title":{"number_of_fragments":0}}`,
+		},
+		{
+			name:  "matched_fields use the fast vector highlighter",
+			text:  "holland",
+			setup: func(sq *searchQuery) { sq.MatchedFields["country"] = []string{"country.plain"} },
+			path:  "highlight/fields/country",
+			want:  `{"matched_fields":["country","country.plain"],"number_of_fragments":0,"type":"fvh"}`,
+		},
+		{
+			name:  "lang targets the language sub-field",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Lang = "de" },
+			path:  "query/bool/should/0/match/last_name.de/query",
+			want:  `"rob"`,
+		},
+		{
+			name:  "lang leaves other fields",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Lang = "de" },
+			path:  "query/bool/should/2/match/country/query",
+			want:  `"rob"`,
+		},
+		{
+			name:  "phonetic targets the phonetic sub-field",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Phonetic = true },
+			path:  "query/bool/should/1/match/first_name.phonetic/query",
+			want:  `"rob"`,
+		},
+		{
+			name: "highlight_fields highlight the phonetic sub-field",
+			text: "rob",
+			setup: func(sq *searchQuery) {
+				sq.Phonetic = true
+				sq.HighlightFields = []string{"last_name", "title"}
+			},
+			path: "highlight/fields",
+			want: `{"last_name.phonetic":{"number_of_fragments":0},"title":{"number_of_fragments":0}}`,
+		},
+		{
+			name: "highlight_fields highlight the language sub-field",
+			text: "rob",
+			setup: func(sq *searchQuery) {
+				sq.Lang = "de"
+				sq.HighlightFields = []string{"first_name"}
+			},
+			path: "highlight/fields",
+			want: `{"first_name.de":{"number_of_fragments":0}}`,
+		},
+		{
+			name:  "phonetic highlights the phonetic sub-field",
+			text:  "rob",
+			setup: func(sq *searchQuery) { sq.Phonetic = true },
+			path:  "highlight/fields/last_name.phonetic",
+			want:  `{"number_of_fragments":0}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sq := newSearchQuery(tt.text)
+			if tt.setup != nil {
+				tt.setup(&sq)
+			}
+			if got := jsonAt(t, queryBody(sq), tt.path); got != tt.want {
+				t.Errorf("%s = %s, want %s", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	defer func(saved bool) { lowercaseQuery = saved }(lowercaseQuery)
+
+	tests := []struct {
+		in        string
+		lowercase bool
+		want      string
+	}{
+		{"  Rob   Pike ", false, "Rob Pike"},
+		{"Rob\t\nPike", false, "Rob Pike"},
+		{"   ", false, ""},
+		{"Pike", false, "Pike"},
+		{"  Rob   PIKE ", true, "rob pike"},
+		{"ÉMILE Zola", true, "émile zola"},
+	}
+	for _, tt := range tests {
+		lowercaseQuery = tt.lowercase
+		if got := normalizeQuery(tt.in); got != tt.want {
+			t.Errorf("normalizeQuery(%q) with lowercase %v = %q, want %q", tt.in, tt.lowercase, got, tt.want)
+		}
+	}
+}
+
+// TestParseSearchQueryNormalizes checks the percent-decoded q parameter is
+// normalized.
+func TestParseSearchQueryNormalizes(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/search?q=%20%20Rob%20%20%20Pike%20", nil)
+	sq, err := parseSearchQuery(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sq.Text != "Rob Pike" {
+		t.Errorf("Text = %q, want %q", sq.Text, "Rob Pike")
+	}
+}
+
+// TestQueryBodySizeZero checks an aggregation-only search fetches no hits
+// and leaves out what only applies to them.
+func TestQueryBodySizeZero(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/search?q=doe&size=0&aggs=country", nil)
+	sq, err := parseSearchQuery(httptest.NewRecorder(), r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := queryBody(sq)
+	if got := jsonAt(t, body, "size"); got != "0" {
+		t.Errorf("size = %s, want 0", got)
+	}
+	for _, key := range []string{"sort", "highlight", "from", "collapse"} {
+		if got := jsonAt(t, body, key); got != "" {
+			t.Errorf("%s = %s, want none with size 0", key, got)
+		}
+	}
+	if got, want := jsonAt(t, body, "aggs/country/terms/field"), `"country.keyword"`; got != want {
+		t.Errorf("aggs/country/terms/field = %s, want %s", got, want)
+	}
+}
+
+func TestSetFieldOperators(t *testing.T) {
+	saved := append([]searchField(nil), searchFields...)
+	defer func() { searchFields = saved }()
+
+	if err := setFieldOperators("country:or,title:or"); err != nil {
+		t.Fatal(err)
+	}
+
+	sq := newSearchQuery("rob pike")
+	sq.Must = []searchClause{{Field: "country", Query: "new zealand"}}
+	body := queryBody(sq)
+	tests := []struct{ path, want string }{
+		{"query/bool/should/0/match/last_name/operator", `"and"`},
+		{"query/bool/should/2/match/country/operator", `"or"`},
+		{"query/bool/should/3/match/title/operator", `"or"`},
+		{"query/bool/must/0/match/country/operator", `"or"`},
+	}
+	for _, tt := range tests {
+		if got := jsonAt(t, body, tt.path); got != tt.want {
+			t.Errorf("%s = %s, want %s", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetFieldOperatorsInvalid(t *testing.T) {
+	saved := append([]searchField(nil), searchFields...)
+	defer func() { searchFields = saved }()
+
+	for _, v := range []string{"country", "nickname:or", "country:xor", "country:or,title"} {
+		if err := setFieldOperators(v); err == nil {
+			t.Errorf("setFieldOperators(%q) succeeded, want an error", v)
+		}
+	}
+}
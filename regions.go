@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// unknownRegion groups countries missing from the regions file.
+const unknownRegion = "Other"
+
+type regionCount struct {
+	Region    string   `json:"region"`
+	Count     int      `json:"count"`
+	Countries []string `json:"countries"`
+}
+
+// loadRegions reads a JSON object mapping country names to regions. Country
+// names are matched case-insensitively.
+func loadRegions(path string) (map[string]string, error) {
+	regions := map[string]string{}
+	if path == "" {
+		return regions, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]string
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	for country, region := range raw {
+		regions[strings.ToLower(country)] = region
+	}
+
+	return regions, nil
+}
+
+// regionsHandler counts people per region by aggregating on country and
+// folding the country buckets into regions, so the mapping can change
+// without reindexing.
+func regionsHandler(logger *log.Logger, es *elasticsearch.Client,
+	regions map[string]string) http.HandlerFunc {
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		minCount, err := intParam(r, "min_count", 1)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		res, err := es.Search(
+			es.Search.WithContext(r.Context()),
+			es.Search.WithIndex(peopleIndex),
+			es.Search.WithBody(buildRegionsQuery(minCount, includeAll(r))),
+		)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		var body struct {
+			Aggregations struct {
+				Countries struct {
+					Buckets []struct {
+						Key      string `json:"key"`
+						DocCount int    `json:"doc_count"`
+					} `json:"buckets"`
+				} `json:"countries"`
+			} `json:"aggregations"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		byRegion := map[string]*regionCount{}
+		for _, b := range body.Aggregations.Countries.Buckets {
+			region, ok := regions[strings.ToLower(b.Key)]
+			if !ok {
+				region = unknownRegion
+			}
+
+			rc, ok := byRegion[region]
+			if !ok {
+				rc = &regionCount{Region: region}
+				byRegion[region] = rc
+			}
+			rc.Count += b.DocCount
+			rc.Countries = append(rc.Countries, b.Key)
+		}
+
+		counts := make([]regionCount, 0, len(byRegion))
+		for _, rc := range byRegion {
+			counts = append(counts, *rc)
+		}
+		sort.Slice(counts, func(i, j int) bool {
+			if counts[i].Count != counts[j].Count {
+				return counts[i].Count > counts[j].Count
+			}
+			return counts[i].Region < counts[j].Region
+		})
+
+		writeJSON(w, http.StatusOK, map[string][]regionCount{"regions": counts})
+	}
+}
+
+// buildRegionsQuery aggregates the people in scope of -default-filter on
+// country, leaving out the countries of fewer than minCount people.
+func buildRegionsQuery(minCount int, all bool) io.Reader {
+	body := map[string]interface{}{
+		"size":  0,
+		"query": scoped(map[string]interface{}{"match_all": map[string]interface{}{}}, all),
+		"aggs": map[string]interface{}{
+			"countries": map[string]interface{}{
+				"terms": map[string]interface{}{"field": "country.keyword", "size": 10000, "min_doc_count": minCount},
+			},
+		},
+	}
+
+	payload, _ := json.Marshal(body)
+	return bytes.NewReader(payload)
+}
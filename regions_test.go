@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// regionsClient serves two country buckets and records the search body.
+func regionsClient(t *testing.T, body *map[string]interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(b, body); err != nil {
+			t.Errorf("search body %s: %v", b, err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"aggregations":{"countries":{"buckets":[` +
+			`{"key":"Neverland","doc_count":2},{"key":"The Netherlands","doc_count":1}]}}}`))
+	}
+}
+
+func TestRegionsScoped(t *testing.T) {
+	defer func(saved map[string]interface{}) { defaultFilter = saved }(defaultFilter)
+	defaultFilter = map[string]interface{}{"term": map[string]interface{}{"active": true}}
+
+	tests := []struct {
+		name, target string
+		want         string
+	}{
+		{
+			"default filter",
+			"/regions",
+			`{"bool":{"filter":{"term":{"active":true}},"must":{"match_all":{}}}}`,
+		},
+		{"include_all", "/regions?include_all=true", `{"match_all":{}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			es := newTestClient(t, regionsClient(t, &body))
+			regions := map[string]string{"the netherlands": "Europe"}
+
+			rec := httptest.NewRecorder()
+			regionsHandler(discardLogger, es, regions)(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+			}
+			if got := jsonAt(t, body, "query"); got != tt.want {
+				t.Errorf("query = %s, want %s", got, tt.want)
+			}
+			want := `{"regions":[{"region":"Other","count":2,"countries":["Neverland"]},` +
+				`{"region":"Europe","count":1,"countries":["The Netherlands"]}]}` + "\n"
+			if rec.Body.String() != want {
+				t.Errorf("body = %s, want %s", rec.Body, want)
+			}
+		})
+	}
+}
+
+func TestRegionsMinCount(t *testing.T) {
+	tests := []struct {
+		name, target string
+		code         int
+		want         string
+	}{
+		{"default", "/regions", http.StatusOK, `1`},
+		{"min_count", "/regions?min_count=2", http.StatusOK, `2`},
+		{"zero", "/regions?min_count=0", http.StatusBadRequest, ``},
+		{"not a number", "/regions?min_count=many", http.StatusBadRequest, ``},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			es := newTestClient(t, regionsClient(t, &body))
+
+			rec := httptest.NewRecorder()
+			regionsHandler(discardLogger, es, nil)(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if rec.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.code, rec.Body)
+			}
+			if got := jsonAt(t, body, "aggs/countries/terms/min_doc_count"); got != tt.want {
+				t.Errorf("min_doc_count = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
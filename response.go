@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorResponse{Error: msg})
+}
+
+// statusClientClosedRequest is the non-standard status, borrowed from nginx,
+// recorded for calls abandoned because the client went away.
+const statusClientClosedRequest = 499
+
+// writeESError reports an Elasticsearch error with the status it carries, or
+// a failed call to Elasticsearch as a 500. Calls short-circuited by an open
+// circuit breaker are reported as 503, calls cut by a timeout as 504 and
+// calls abandoned by the client as 499, which isn't a server error.
+func writeESError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.Canceled) {
+		writeError(w, statusClientClosedRequest, "client closed request")
+		return
+	}
+	if errors.Is(err, errBreakerOpen) {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, err.Error())
+		return
+	}
+
+	var e *eserr.Error
+	if errors.As(err, &e) {
+		writeError(w, e.Status, e.Reason)
+		return
+	}
+
+	writeError(w, eserr.Status(err), err.Error())
+}
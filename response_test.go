@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+func TestWriteESError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "malformed query",
+			err:        eserr.Decode(400, []byte(`{"error":{"type":"parsing_exception","reason":"unknown query [mach]"},"status":400}`)),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"unknown query [mach]"}`,
+		},
+		{
+			name:       "missing index",
+			err:        eserr.Decode(404, []byte(`{"error":{"type":"index_not_found_exception","reason":"no such index [people]"},"status":404}`)),
+			wantStatus: http.StatusNotFound,
+			wantBody:   `{"error":"no such index [people]"}`,
+		},
+		{
+			name:       "upstream proxy error",
+			err:        eserr.Decode(502, []byte(`<html>Bad Gateway</html>`)),
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `{"error":"Bad Gateway"}`,
+		},
+		{
+			name:       "breaker open",
+			err:        fmt.Errorf("search: %w", errBreakerOpen),
+			wantStatus: http.StatusServiceUnavailable,
+			wantBody:   `{"error":"search: elasticsearch circuit breaker is open"}`,
+		},
+		{
+			name:       "timeout",
+			err:        context.DeadlineExceeded,
+			wantStatus: http.StatusGatewayTimeout,
+			wantBody:   `{"error":"context deadline exceeded"}`,
+		},
+		{
+			name:       "client gone",
+			err:        fmt.Errorf("search: %w", context.Canceled),
+			wantStatus: statusClientClosedRequest,
+			wantBody:   `{"error":"client closed request"}`,
+		},
+		{
+			name:       "transport error",
+			err:        errors.New("dial tcp: connection refused"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   `{"error":"dial tcp: connection refused"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			writeESError(rec, tt.err)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Body.String(); got != tt.wantBody+"\n" {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			if got := rec.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+		})
+	}
+}
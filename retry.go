@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// retryable reports whether a failed call to the cluster may succeed when
+// repeated: transport errors other than an open breaker or a cancelled
+// request, and responses of an overloaded or starting cluster.
+func retryable(res *esapi.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, errBreakerOpen) &&
+			!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+
+	switch res.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// withRetry calls do up to 1+retries times while its result is retryable,
+// sleeping backoff before the first retry and doubling it after each, and
+// returns the last result. It gives up early when ctx is done.
+func withRetry(ctx context.Context, retries int, backoff time.Duration,
+	do func() (*esapi.Response, error)) (*esapi.Response, error) {
+
+	res, err := do()
+	for attempt := 0; attempt < retries && retryable(res, err); attempt++ {
+		if res != nil {
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff << attempt)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		res, err = do()
+	}
+
+	return res, err
+}
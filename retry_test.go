@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+)
+
+// TestWithRetryAttempts checks a search against an unavailable cluster is
+// attempted 1+retries times in all, without the client retrying as well.
+func TestWithRetryAttempts(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	es := newEsClient(discardLogger, []string{srv.URL})
+
+	res, err := withRetry(context.Background(), 2, time.Millisecond, func() (*esapi.Response, error) {
+		return es.Search()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want 503", res.StatusCode)
+	}
+	if got := attempts.Load(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+}
+
+func TestWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	_, err := withRetry(ctx, 5, time.Hour, func() (*esapi.Response, error) {
+		calls++
+		cancel()
+		return &esapi.Response{StatusCode: http.StatusBadGateway, Body: http.NoBody}, nil
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("withRetry() = %v after %d calls, want %v after 1", err, calls, context.Canceled)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// esSearchResponse is the subset of an Elasticsearch search response the API
+// relies on.
+type esSearchResponse struct {
+	Took            int  `json:"took"`
+	TerminatedEarly bool `json:"terminated_early"`
+	Shards          struct {
+		Total    int `json:"total"`
+		Failed   int `json:"failed"`
+		Failures []struct {
+			Index  string `json:"index"`
+			Shard  int    `json:"shard"`
+			Reason struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"reason"`
+		} `json:"failures"`
+	} `json:"_shards"`
+	Hits struct {
+		Total *struct {
+			Value    int    `json:"value"`
+			Relation string `json:"relation"`
+		} `json:"total"`
+		Hits []esHit `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]struct {
+		Buckets []struct {
+			Key      string `json:"key"`
+			DocCount int    `json:"doc_count"`
+		} `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+// esHit is a hit of an Elasticsearch search response.
+type esHit struct {
+	ID          string              `json:"_id"`
+	Score       *float64            `json:"_score"`
+	Source      *Person             `json:"_source"`
+	Highlight   map[string][]string `json:"highlight"`
+	Explanation json.RawMessage     `json:"_explanation"`
+	InnerHits   struct {
+		Group *struct {
+			Hits struct {
+				Total struct {
+					Value int `json:"value"`
+				} `json:"total"`
+			} `json:"hits"`
+		} `json:"group"`
+	} `json:"inner_hits"`
+}
+
+// result returns the API representation of the hit.
+func (h esHit) result() searchResult {
+	result := searchResult{
+		Person:      h.Source,
+		Score:       h.Score,
+		Explanation: h.Explanation,
+	}
+	result.setHighlight(h.Highlight)
+	if g := h.InnerHits.Group; g != nil {
+		result.GroupSize = &g.Hits.Total.Value
+	}
+
+	return result
+}
+
+// searchResponse is the API representation of a search. TotalRelation is
+// "gte" when Total is only a lower bound.
+type searchResponse struct {
+	Took          int    `json:"took"`
+	Total         *int   `json:"total,omitempty"`
+	TotalRelation string `json:"total_relation,omitempty"`
+	// TerminatedEarly is set when terminate_after stopped a shard, making
+	// Total a lower bound.
+	TerminatedEarly bool                   `json:"terminated_early,omitempty"`
+	Results         []searchResult         `json:"results"`
+	Aggregations    map[string][]aggBucket `json:"aggregations,omitempty"`
+	Warnings        []string               `json:"warnings,omitempty"`
+}
+
+// aggBucket is the number of people sharing a field value.
+type aggBucket struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type searchResult struct {
+	*Person
+	// Score is the relevance of the hit, absent when the results are not
+	// sorted by score.
+	Score     *float64            `json:"score,omitempty"`
+	Highlight map[string][]string `json:"highlight,omitempty"`
+	// HighlightOffsets holds the matches of each highlighted field as
+	// character offsets, with highlight_offsets=true.
+	HighlightOffsets map[string][]highlightOffset `json:"highlight_offsets,omitempty"`
+	// Explanation is the scoring explanation of the hit, only present when
+	// the search was run with explain=true.
+	Explanation json.RawMessage `json:"explanation,omitempty"`
+	// GroupSize is the number of people collapsed into this result, only
+	// present with collapse_counts=true.
+	GroupSize *int `json:"group_size,omitempty"`
+}
+
+// idsResponse is the API representation of an ids_only search.
+type idsResponse struct {
+	Took          int     `json:"took"`
+	Total         *int    `json:"total,omitempty"`
+	TotalRelation string  `json:"total_relation,omitempty"`
+	IDs           []idHit `json:"ids"`
+}
+
+// idHit is a matching person. Score is null when the results are not sorted
+// by score.
+type idHit struct {
+	ID    string   `json:"id"`
+	Score *float64 `json:"score"`
+}
+
+// transformIDs decodes an Elasticsearch search response from r into the IDs
+// of its hits.
+func transformIDs(r io.Reader) (idsResponse, error) {
+	var res esSearchResponse
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return idsResponse{}, err
+	}
+
+	out := idsResponse{Took: res.Took, IDs: make([]idHit, 0, len(res.Hits.Hits))}
+	if t := res.Hits.Total; t != nil {
+		out.Total = &t.Value
+		if t.Relation != "eq" {
+			out.TotalRelation = t.Relation
+		}
+	}
+	for _, hit := range res.Hits.Hits {
+		out.IDs = append(out.IDs, idHit{ID: hit.ID, Score: hit.Score})
+	}
+
+	return out, nil
+}
+
+// highlightStart and highlightEnd delimit the matches in the highlights
+// requested with highlight_offsets=true. Control characters can't clash with
+// markup in the field values.
+const (
+	highlightStart = "\x02"
+	highlightEnd   = "\x03"
+)
+
+// highlightOffset is a match in a field value, from the character at Start
+// up to the one before End; characters are Unicode code points.
+type highlightOffset struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// setHighlight stores the highlights of a hit, converted to offsets when
+// they are delimited with highlightStart and highlightEnd.
+func (r *searchResult) setHighlight(highlight map[string][]string) {
+	for _, fragments := range highlight {
+		if len(fragments) > 0 && strings.Contains(fragments[0], highlightStart) {
+			r.HighlightOffsets = make(map[string][]highlightOffset, len(highlight))
+			for field, fragments := range highlight {
+				r.HighlightOffsets[field] = matchOffsets(strings.Join(fragments, ""))
+			}
+			return
+		}
+	}
+
+	r.Highlight = highlight
+}
+
+// matchOffsets returns the offsets of the delimited matches in the value
+// highlighted as a whole.
+func matchOffsets(highlighted string) []highlightOffset {
+	var offsets []highlightOffset
+	pos, start := 0, -1
+	for _, c := range highlighted {
+		switch string(c) {
+		case highlightStart:
+			start = pos
+		case highlightEnd:
+			if start >= 0 {
+				offsets = append(offsets, highlightOffset{Start: start, End: pos})
+				start = -1
+			}
+		default:
+			pos++
+		}
+	}
+
+	return offsets
+}
+
+// transformSearch decodes an Elasticsearch search response from r into its
+// API representation. Partially failed searches are reported in warnings
+// rather than passed off as complete results.
+func transformSearch(r io.Reader) (searchResponse, error) {
+	var res esSearchResponse
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return searchResponse{}, err
+	}
+
+	out := searchResponse{
+		Took:            res.Took,
+		TerminatedEarly: res.TerminatedEarly,
+		Results:         make([]searchResult, 0, len(res.Hits.Hits)),
+	}
+	if t := res.Hits.Total; t != nil {
+		out.Total = &t.Value
+		if t.Relation != "eq" {
+			out.TotalRelation = t.Relation
+		}
+	}
+	for _, hit := range res.Hits.Hits {
+		out.Results = append(out.Results, hit.result())
+	}
+
+	if len(res.Aggregations) > 0 {
+		out.Aggregations = make(map[string][]aggBucket, len(res.Aggregations))
+		for name, agg := range res.Aggregations {
+			buckets := make([]aggBucket, 0, len(agg.Buckets))
+			for _, b := range agg.Buckets {
+				buckets = append(buckets, aggBucket{Value: b.Key, Count: b.DocCount})
+			}
+			out.Aggregations[name] = buckets
+		}
+	}
+
+	if res.Shards.Failed > 0 {
+		out.Warnings = append(out.Warnings, fmt.Sprintf(
+			"%d of %d shards failed, results may be incomplete", res.Shards.Failed, res.Shards.Total))
+		for _, f := range res.Shards.Failures {
+			out.Warnings = append(out.Warnings, fmt.Sprintf(
+				"shard %d of %q: %s: %s", f.Shard, f.Index, f.Reason.Type, f.Reason.Reason))
+		}
+	}
+
+	return out, nil
+}
+
+// setTotalHits reports the hit count in the X-Total-Hits header, for clients
+// that don't parse the body. When the count stopped at the track_total
+// threshold X-Total-Hits-Relation is "gte", like total_relation in the body.
+func setTotalHits(w http.ResponseWriter, total int, relation string) {
+	w.Header().Set("X-Total-Hits", strconv.Itoa(total))
+	if relation != "" && relation != "eq" {
+		w.Header().Set("X-Total-Hits-Relation", relation)
+	}
+}
+
+func encodeSearchJSON(w io.Writer, res searchResponse) error {
+	return json.NewEncoder(w).Encode(res)
+}
+
+func encodeIDsJSON(w io.Writer, res idsResponse) error {
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransformSearchSizeZero(t *testing.T) {
+	res := `{"took":2,"hits":{"total":{"value":3,"relation":"eq"},"hits":[]},` +
+		`"aggregations":{"country":{"buckets":[{"key":"Neverland","doc_count":3}]}}}`
+
+	out, err := transformSearch(strings.NewReader(res))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Results == nil || len(out.Results) != 0 {
+		t.Errorf("Results = %#v, want an empty list", out.Results)
+	}
+	if out.Total == nil || *out.Total != 3 {
+		t.Errorf("Total = %v, want 3", out.Total)
+	}
+	if got := out.Aggregations["country"]; len(got) != 1 || got[0] != (aggBucket{Value: "Neverland", Count: 3}) {
+		t.Errorf("Aggregations[country] = %v, want Neverland: 3", got)
+	}
+}
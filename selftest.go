@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+// selfTest indexes a probe document, finds it through the regular search
+// query, deletes it again and logs the outcome of every step. It reports
+// whether all steps succeeded.
+func selfTest(es *elasticsearch.Client, logger *log.Logger) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	probe := &Person{
+		ID:        "selftest-" + newRequestID(),
+		Title:     "Dr.",
+		FirstName: "Selftest",
+		LastName:  "Probe" + newRequestID(),
+		Email:     "selftest@example.com",
+		Country:   "Nowhere",
+	}
+
+	steps := []struct {
+		name string
+		run  func() error
+	}{
+		{"index probe document", func() error {
+			payload, err := json.Marshal(probe)
+			if err != nil {
+				return err
+			}
+			res, err := esapi.IndexRequest{
+				Index:      peopleIndex,
+				DocumentID: probe.ID,
+				Body:       bytes.NewReader(payload),
+				Refresh:    "wait_for",
+			}.Do(ctx, es)
+			return checkResponse(res, err)
+		}},
+		{"search probe document", func() error {
+			res, err := es.Search(
+				es.Search.WithContext(ctx),
+				es.Search.WithIndex(peopleIndex),
+				es.Search.WithBody(buildQuery(newSearchQuery(probe.LastName))),
+			)
+			if err != nil {
+				return err
+			}
+			defer res.Body.Close()
+			if err := eserr.FromResponse(res); err != nil {
+				return err
+			}
+
+			var body esSearchResponse
+			if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+				return err
+			}
+			for _, hit := range body.Hits.Hits {
+				if hit.Source != nil && hit.Source.ID == probe.ID {
+					return nil
+				}
+			}
+			return fmt.Errorf("probe %q not found searching for %q", probe.ID, probe.LastName)
+		}},
+		{"delete probe document", func() error {
+			res, err := esapi.DeleteRequest{
+				Index:      peopleIndex,
+				DocumentID: probe.ID,
+				Refresh:    "true",
+			}.Do(ctx, es)
+			return checkResponse(res, err)
+		}},
+	}
+
+	ok := true
+	for _, step := range steps {
+		start := time.Now()
+		if err := step.run(); err != nil {
+			logger.Printf("selftest: %-22s FAIL (%s): %v", step.name, time.Since(start), err)
+			ok = false
+			continue
+		}
+		logger.Printf("selftest: %-22s ok (%s)", step.name, time.Since(start))
+	}
+
+	return ok
+}
+
+// checkResponse closes res and returns the transport or Elasticsearch error,
+// if any.
+func checkResponse(res *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		return err
+	}
+	io.Copy(io.Discard, res.Body)
+
+	return nil
+}
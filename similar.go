@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+const (
+	defaultMinTermFreq   = 1
+	defaultMaxQueryTerms = 25
+)
+
+// similarFields are the text fields of a person used to seed more_like_this.
+var similarFields = []string{"title", "first_name", "last_name", "country"}
+
+func similarPeople(w http.ResponseWriter, r *http.Request, reads *failover, id string) {
+	minTermFreq, err := intParam(r, "min_term_freq", defaultMinTermFreq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxQueryTerms, err := intParam(r, "max_query_terms", defaultMaxQueryTerms)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	es := reads.client()
+	res, err := es.Search(
+		es.Search.WithContext(r.Context()),
+		es.Search.WithIndex(peopleIndex),
+		es.Search.WithBody(buildSimilarQuery(id, minTermFreq, maxQueryTerms, includeAll(r))),
+	)
+	reads.report(es, res, err)
+	if err != nil {
+		writeESError(w, err)
+		return
+	}
+	defer res.Body.Close()
+
+	if err := eserr.FromResponse(res); err != nil {
+		writeESError(w, err)
+		return
+	}
+
+	out, err := transformSearch(res.Body)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	if out.Total != nil {
+		setTotalHits(w, *out.Total, out.TotalRelation)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encodeSearchJSON(jsonOutput(w, r), out)
+}
+
+func buildSimilarQuery(id string, minTermFreq, maxQueryTerms int, all bool) io.Reader {
+	body := map[string]interface{}{
+		"query": scoped(map[string]interface{}{
+			"more_like_this": map[string]interface{}{
+				"fields":          similarFields,
+				"like":            []map[string]string{{"_index": peopleIndex, "_id": id}},
+				"min_term_freq":   minTermFreq,
+				"min_doc_freq":    1,
+				"max_query_terms": maxQueryTerms,
+			},
+		}, all),
+		"size": 25,
+	}
+
+	payload, _ := json.Marshal(body)
+	return bytes.NewReader(payload)
+}
+
+// intParam parses a positive integer query parameter, returning def when the
+// parameter is absent.
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+
+	return n, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestSimilarPeopleTransformed checks similar people are returned as search
+// results, through the output options of the request.
+func TestSimilarPeopleTransformed(t *testing.T) {
+	es := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"took":1,"hits":{"total":{"value":1,"relation":"eq"},"hits":[` +
+			`{"_id":"3","_score":1.5,"_source":{"id":"3","first_name":"Jane","last_name":"Doe"}}]}}`))
+	})
+	reads := &failover{primary: es}
+
+	rec := httptest.NewRecorder()
+	similarPeople(rec, httptest.NewRequest(http.MethodGet, "/people/2/similar?case=camel", nil), reads, "2")
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	want := `{"took":1,"total":1,"results":[{"id":"3","title":"","firstName":"Jane","lastName":"Doe",` +
+		`"email":"","country":"","score":1.5}]}` + "\n"
+	if rec.Body.String() != want {
+		t.Errorf("body = %s, want %s", rec.Body, want)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+type slowEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// slowLog keeps the last searches slower than a threshold in a fixed size
+// ring buffer, overwriting the oldest entry once it is full.
+type slowLog struct {
+	threshold time.Duration
+
+	mu      sync.Mutex
+	entries []slowEntry
+	next    int
+	full    bool
+}
+
+func newSlowLog(size int, threshold time.Duration) *slowLog {
+	return &slowLog{threshold: threshold, entries: make([]slowEntry, size)}
+}
+
+func (l *slowLog) record(r *http.Request, elapsed time.Duration) {
+	if len(l.entries) == 0 || elapsed < l.threshold {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries[l.next] = slowEntry{
+		Time:       time.Now().Add(-elapsed),
+		RequestID:  requestID(r.Context()),
+		Method:     r.Method,
+		URI:        r.URL.RequestURI(),
+		DurationMs: float64(elapsed) / float64(time.Millisecond),
+	}
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// snapshot returns the recorded entries, slowest first.
+func (l *slowLog) snapshot() []slowEntry {
+	l.mu.Lock()
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+	entries := make([]slowEntry, n)
+	copy(entries, l.entries[:n])
+	l.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].DurationMs > entries[j].DurationMs
+	})
+
+	return entries
+}
+
+type slowLogResponse struct {
+	ThresholdMs float64     `json:"threshold_ms"`
+	Entries     []slowEntry `json:"entries"`
+}
+
+func slowLogHandler(logger *log.Logger, slow *slowLog) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		writeJSON(w, http.StatusOK, slowLogResponse{
+			ThresholdMs: float64(slow.threshold) / float64(time.Millisecond),
+			Entries:     slow.snapshot(),
+		})
+	}
+}
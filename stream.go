@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/rafael-henrique-oliveira/es-demo/ndjson"
+)
+
+// streamsResults reports whether the client asked for results to be streamed
+// with stream=true.
+func streamsResults(r *http.Request) bool {
+	return r.URL.Query().Get("stream") == "true"
+}
+
+// streamSearch decodes the hits of an Elasticsearch search response from r
+// one at a time and writes each as a line of JSON to w, without holding the
+// whole page in memory. Results carry the same fields as transformSearch
+// gives them. onTotal is called with the hit count, which Elasticsearch
+// sends ahead of the hits, before anything is written. Everything else is
+// skipped.
+func streamSearch(r io.Reader, w io.Writer, onTotal func(total int, relation string)) error {
+	dec := json.NewDecoder(r)
+	enc := ndjson.NewWriter(w)
+
+	return walkObject(dec, func(key string) error {
+		if key != "hits" {
+			return skipValue(dec)
+		}
+		return walkObject(dec, func(key string) error {
+			if key == "total" {
+				var total *struct {
+					Value    int    `json:"value"`
+					Relation string `json:"relation"`
+				}
+				if err := dec.Decode(&total); err != nil {
+					return err
+				}
+				if total != nil {
+					onTotal(total.Value, total.Relation)
+				}
+				return nil
+			}
+			if key != "hits" {
+				return skipValue(dec)
+			}
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var hit esHit
+				if err := dec.Decode(&hit); err != nil {
+					return err
+				}
+				if err := enc.Encode(hit.result()); err != nil {
+					return err
+				}
+			}
+			return expectDelim(dec, ']')
+		})
+	})
+}
+
+// walkObject reads a JSON object from dec, calling field for every key with
+// the decoder positioned at its value. field must consume the value.
+func walkObject(dec *json.Decoder, field func(key string) error) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("unexpected %v in search response, expected a key", t)
+		}
+		if err := field(key); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if d, ok := t.(json.Delim); !ok || d != want {
+		return fmt.Errorf("unexpected %v in search response, expected %v", t, want)
+	}
+
+	return nil
+}
+
+func skipValue(dec *json.Decoder) error {
+	var v json.RawMessage
+	return dec.Decode(&v)
+}
+
+// flushWriter flushes every write to the client so streamed results are sent
+// as soon as they are encoded.
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if fl, ok := f.w.(http.Flusher); ok {
+		fl.Flush()
+	}
+
+	return n, err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const streamResponse = `{"took":1,"hits":{"total":{"value":2,"relation":"eq"},"hits":[` +
+	`{"_id":"1","_score":2,"_source":{"id":"1","first_name":"Rob"},"_explanation":{"value":2},` +
+	`"inner_hits":{"group":{"hits":{"total":{"value":3}}}}},` +
+	`{"_id":"2","_score":1,"_source":{"id":"2","first_name":"Jane"}}]},"aggregations":{}}`
+
+// TestStreamSearchFields checks streamed results carry the fields of the
+// JSON response.
+func TestStreamSearchFields(t *testing.T) {
+	var buf strings.Builder
+	var total int
+	err := streamSearch(strings.NewReader(streamResponse), &buf, func(n int, _ string) { total = n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 2 {
+		t.Errorf("total = %d, want 2", total)
+	}
+
+	want := `{"id":"1","title":"","first_name":"Rob","last_name":"","email":"","country":"",` +
+		`"score":2,"explanation":{"value":2},"group_size":3}` + "\n" +
+		`{"id":"2","title":"","first_name":"Jane","last_name":"","email":"","country":"","score":1}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+}
+
+func TestStreamSearchOutput(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/search?stream=true&case=camel&pretty=true", nil)
+
+	var buf strings.Builder
+	if err := streamSearch(strings.NewReader(streamResponse), jsonOutput(&buf, r), func(int, string) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := buf.String()
+	if !strings.Contains(got, "\n  \"firstName\": \"Rob\",\n") {
+		t.Errorf("got %s, want indented camelCase results", got)
+	}
+	if strings.Contains(got, "group_size") || !strings.Contains(got, `"groupSize": 3`) {
+		t.Errorf("got %s, want camelCase group size", got)
+	}
+}
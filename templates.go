@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v7"
+	"github.com/elastic/go-elasticsearch/v7/esapi"
+	"github.com/rafael-henrique-oliveira/es-demo/eserr"
+)
+
+var validTemplateName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// putSearchTemplateHandler stores the mustache search template of
+// PUT /search-templates/{name}, given as {"source": {...}}, on the cluster.
+func putSearchTemplateHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPut {
+			w.Header().Set("Allow", "PUT")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/search-templates/")
+		if !validTemplateName.MatchString(name) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid template name %q", name))
+			return
+		}
+
+		var body struct {
+			Source json.RawMessage `json:"source"`
+		}
+		if err := decodeJSONBody(w, r, &body, true); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if len(body.Source) == 0 {
+			writeError(w, http.StatusBadRequest, "source is required")
+			return
+		}
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"script": map[string]interface{}{"lang": "mustache", "source": body.Source},
+		})
+		res, err := esapi.PutScriptRequest{
+			ScriptID: name,
+			Body:     bytes.NewReader(payload),
+		}.Do(r.Context(), es)
+		if err := checkResponse(res, err); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"template": name})
+	}
+}
+
+// searchTemplateBody is the body of POST /search/template.
+type searchTemplateBody struct {
+	ID     string                 `json:"id"`
+	Params map[string]interface{} `json:"params"`
+}
+
+// searchTemplateHandler runs a stored search template against the people
+// index with the given params and responds like /search.
+func searchTemplateHandler(logger *log.Logger, es *elasticsearch.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger.Println(r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent())
+
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body searchTemplateBody
+		if err := decodeJSONBody(w, r, &body, true); err != nil {
+			writeError(w, bodyErrorStatus(err), fmt.Sprintf("invalid body: %v", err))
+			return
+		}
+		if !validTemplateName.MatchString(body.ID) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid template id %q", body.ID))
+			return
+		}
+
+		payload, _ := json.Marshal(body)
+		res, err := esapi.SearchTemplateRequest{
+			Index: []string{peopleIndex},
+			Body:  bytes.NewReader(payload),
+		}.Do(r.Context(), es)
+		if err != nil {
+			writeESError(w, err)
+			return
+		}
+		defer res.Body.Close()
+
+		if err := eserr.FromResponse(res); err != nil {
+			writeESError(w, err)
+			return
+		}
+
+		out, err := transformSearch(res.Body)
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		if out.Total != nil {
+			setTotalHits(w, *out.Total, out.TotalRelation)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := encodeSearchJSON(jsonOutput(w, r), out); err != nil {
+			logger.Println("search aborted:", err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+(function () {
+  var input = document.getElementById('q');
+  var summary = document.getElementById('summary');
+  var results = document.getElementById('results');
+  var timer;
+
+  function escape(s) {
+    var div = document.createElement('div');
+    div.textContent = s == null ? '' : String(s);
+    return div.innerHTML;
+  }
+
+  // Highlight fragments come back from Elasticsearch HTML-escaped with <em>
+  // markup, so they are used as-is while plain source values are escaped.
+  function value(result, field, highlightField) {
+    var hl = result.highlight && result.highlight[highlightField];
+    return hl ? hl.join(' ') : escape(field.split('.').reduce(function (v, key) {
+      return v && v[key];
+    }, result));
+  }
+
+  function render(body) {
+    summary.textContent = body.total + ' result(s) in ' + body.took + 'ms' +
+      (body.warnings ? ' (' + body.warnings[0] + ')' : '');
+    results.innerHTML = body.results.map(function (result) {
+      return '<li>' +
+        value(result, 'title', 'title') + ' ' +
+        value(result, 'first_name', 'first_name') + ' ' +
+        value(result, 'last_name', 'last_name') +
+        '<div class="meta">' + escape(result.email) + ' &middot; ' +
+        value(result, 'country', 'country') +
+        (result.address && result.address.city ?
+          ', ' + value(result, 'address.city', 'address.city') : '') +
+        '</div></li>';
+    }).join('');
+  }
+
+  function search() {
+    var q = input.value.trim();
+    if (!q) {
+      summary.textContent = '';
+      results.innerHTML = '';
+      return;
+    }
+
+    fetch('/search?q=' + encodeURIComponent(q))
+      .then(function (res) { return res.json(); })
+      .then(function (body) {
+        // Unwrap the envelope of servers started with -response-envelope.
+        render(body.meta && body.data ? body.data : body);
+      })
+      .catch(function (err) { summary.textContent = 'Search failed: ' + err; });
+  }
+
+  input.addEventListener('input', function () {
+    clearTimeout(timer);
+    timer = setTimeout(search, 200);
+  });
+})();
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7"
+)
+
+// warmupQueries are representative searches run by -warmup: a name lookup, a
+// fuzzy one, a filtered one and a script sorted one, so both the query paths
+// and the doc values used for sorting and filtering are loaded.
+func warmupQueries() []searchQuery {
+	name := newSearchQuery("doe")
+
+	fuzzy := newSearchQuery("jonh")
+	fuzzy.Fuzziness["first_name"] = "AUTO"
+
+	filtered := newSearchQuery("")
+	filtered.Filters["country"] = "Neverland"
+	filtered.Aggs = []string{"country"}
+
+	sorted := newSearchQuery("rob")
+	sorted.Sort = []string{"full_name"}
+
+	return []searchQuery{name, fuzzy, filtered, sorted}
+}
+
+// warmup primes the filesystem and query caches of the people index before
+// the server accepts traffic. Failures are logged but don't stop startup.
+func warmup(es *elasticsearch.Client, logger *log.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	queries := warmupQueries()
+	failed := 0
+	for _, sq := range queries {
+		res, err := es.Search(
+			es.Search.WithContext(ctx),
+			es.Search.WithIndex(peopleIndex),
+			es.Search.WithBody(buildQuery(sq)),
+			es.Search.WithRequestCache(true),
+		)
+		if err := checkResponse(res, err); err != nil {
+			logger.Printf("warmup: search %q failed: %v", sq.Text, err)
+			failed++
+		}
+	}
+
+	logger.Printf("Warm-up ran %d searches (%d failed) in %s", len(queries), failed, time.Since(start))
+}